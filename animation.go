@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// Animation holds the composited frames of an animated GIF, image is the current frame
+type Animation struct {
+	frames  []*ebiten.Image
+	delays  []int // In 100ths of a second
+	frame   int
+	elapsed float64 // Seconds shown of the current frame
+	playing bool
+	speed   float64
+	onion   bool
+}
+
+const (
+	gifDefaultDelay = 10 // Browsers treat tiny delays as 100ms
+	onionAlpha      = 0.3
+	animInfoYOffset = 16
+)
+
+var animSpeeds = []float64{0.25, 0.5, 1, 2, 4}
+
+// Composites the frames of a GIF honoring their disposal methods, a GIF with
+// a single frame comes back as a plain image instead
+func decodeGIF(data []byte) (*Animation, image.Image, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(g.Image) < 2 {
+		return nil, g.Image[0], nil
+	}
+
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewRGBA(bounds)
+	anim := &Animation{
+		playing: true,
+		speed:   1,
+	}
+	for i, frame := range g.Image {
+		var previous *image.RGBA
+		if i < len(g.Disposal) && g.Disposal[i] == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		anim.frames = append(anim.frames, ebiten.NewImageFromImage(canvas))
+
+		delay := g.Delay[i]
+		if delay < 2 {
+			delay = gifDefaultDelay
+		}
+		anim.delays = append(anim.delays, delay)
+
+		if i < len(g.Disposal) {
+			switch g.Disposal[i] {
+			case gif.DisposalBackground:
+				draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+			case gif.DisposalPrevious:
+				canvas = previous
+			}
+		}
+	}
+	return anim, nil, nil
+}
+
+func (a *Animation) clone() *Animation {
+	if a == nil {
+		return nil
+	}
+	c := *a
+	c.frames = make([]*ebiten.Image, len(a.frames))
+	for i, frame := range a.frames {
+		c.frames[i] = ebiten.NewImage(frame.Bounds().Dx(), frame.Bounds().Dy())
+		c.frames[i].DrawImage(frame, nil)
+	}
+	c.delays = append([]int(nil), a.delays...)
+	return &c
+}
+
+func (v *Visage) showFrame(frame int) {
+	a := v.anim
+	a.frame = (frame + len(a.frames)) % len(a.frames)
+	a.elapsed = 0
+	v.image = a.frames[a.frame]
+}
+
+// Advances playing animations, the selected one holds still while its pixels are edited
+func (g *Game) updateAnimations() {
+	dt := 1 / float64(ebiten.TPS())
+	for i := range g.visages {
+		v := &g.visages[i]
+		if v.anim == nil || !v.anim.playing {
+			continue
+		}
+		if g.selected && g.selectedIndex == i && (g.erasingToggle || g.selectTool != selectNone) {
+			continue
+		}
+
+		a := v.anim
+		a.elapsed += dt * a.speed
+		if delay := float64(a.delays[a.frame]) / 100; a.elapsed >= delay {
+			elapsed := a.elapsed - delay
+			v.showFrame(a.frame + 1)
+			a.elapsed = elapsed
+			g.markDirty()
+		}
+	}
+}
+
+func (g *Game) drawOnionSkin(screen *ebiten.Image, v Visage, op *ebiten.DrawImageOptions) {
+	a := v.anim
+	previous := a.frames[(a.frame-1+len(a.frames))%len(a.frames)]
+	next := a.frames[(a.frame+1)%len(a.frames)]
+
+	prevOp := *op
+	prevOp.ColorScale.Scale(1, 0.5, 0.5, 1)
+	prevOp.ColorScale.ScaleAlpha(onionAlpha)
+	screen.DrawImage(previous, &prevOp)
+
+	nextOp := *op
+	nextOp.ColorScale.Scale(0.5, 0.5, 1, 1)
+	nextOp.ColorScale.ScaleAlpha(onionAlpha)
+	screen.DrawImage(next, &nextOp)
+}
+
+func (g *Game) drawAnimationInfo(screen *ebiten.Image, v Visage) {
+	a := v.anim
+	state := "playing"
+	if !a.playing {
+		state = "paused"
+	}
+	info := fmt.Sprintf("Frame %d/%d x%.2g %s", a.frame+1, len(a.frames), a.speed, state)
+	ebitenutil.DebugPrintAt(screen, info, v.x, v.y-animInfoYOffset)
+}
+
+func (g *Game) selectedAnimation() *Visage {
+	if len(g.visages) == 0 || !g.selected || g.visages[g.selectedIndex].anim == nil {
+		return nil
+	}
+	return &g.visages[g.selectedIndex]
+}
+
+func (g *Game) playPauseAction(selectedIndex int) {
+	if v := g.selectedAnimation(); v != nil {
+		v.anim.playing = !v.anim.playing
+	}
+}
+
+func (g *Game) previousFrameAction(selectedIndex int) {
+	if v := g.selectedAnimation(); v != nil {
+		v.anim.playing = false
+		v.showFrame(v.anim.frame - 1)
+	}
+}
+
+func (g *Game) nextFrameAction(selectedIndex int) {
+	if v := g.selectedAnimation(); v != nil {
+		v.anim.playing = false
+		v.showFrame(v.anim.frame + 1)
+	}
+}
+
+func (g *Game) onionSkinAction(selectedIndex int) {
+	if v := g.selectedAnimation(); v != nil {
+		v.anim.onion = !v.anim.onion
+	}
+}
+
+// Steps the playback speed of the selected animation
+func (g *Game) changeAnimationSpeed(step int) {
+	v := g.selectedAnimation()
+	if v == nil {
+		return
+	}
+
+	for i, speed := range animSpeeds {
+		if speed == v.anim.speed && i+step >= 0 && i+step < len(animSpeeds) {
+			v.anim.speed = animSpeeds[i+step]
+			return
+		}
+	}
+}
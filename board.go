@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+const (
+	boardExt     = ".visage"
+	boardName    = "board" + boardExt
+	exportName   = "board.png"
+	boardVersion = 1
+)
+
+type boardFile struct {
+	Version    int               `json:"version"`
+	Visages    []boardVisage     `json:"visages"`
+	View       *boardView        `json:"view,omitempty"` // Zoom and offset the board was shown at
+	Viewpoints map[int]boardView `json:"viewpoints,omitempty"`
+}
+
+type boardView struct {
+	Zoom float64 `json:"zoom"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+}
+
+type boardVisage struct {
+	ID           int         `json:"id"`
+	X            int         `json:"x"`
+	Y            int         `json:"y"`
+	W            int         `json:"w"`
+	H            int         `json:"h"`
+	Image        []byte      `json:"image,omitempty"`  // PNG encoded
+	Frames       [][]byte    `json:"frames,omitempty"` // PNG encoded animation frames
+	Delays       []int       `json:"delays,omitempty"`
+	SVG          []byte      `json:"svg,omitempty"`         // Source of a vector image
+	Orientation  int         `json:"orientation,omitempty"` // EXIF orientation of the image
+	SharedID     int         `json:"sharedID,omitempty"`    // Visage whose image this one shares
+	Linked       bool        `json:"linked,omitempty"`
+	Note         *boardNote  `json:"note,omitempty"`
+	Shape        *boardShape `json:"shape,omitempty"`
+	Caption      *boardNote  `json:"caption,omitempty"`
+	Meta         *boardMeta  `json:"meta,omitempty"`
+	Locked       bool        `json:"locked,omitempty"`
+	Hidden       bool        `json:"hidden,omitempty"`
+	Transparency int         `json:"transparency,omitempty"`
+	Rotation     int         `json:"rotation,omitempty"` // Clockwise degrees, the pixels are saved as imported
+	FlipX        bool        `json:"flipX,omitempty"`
+	FlipY        bool        `json:"flipY,omitempty"`
+}
+
+type boardNote struct {
+	Text   string     `json:"text"`
+	Size   float64    `json:"size"`
+	Color  color.RGBA `json:"color"`
+	Sticky bool       `json:"sticky,omitempty"`
+}
+
+type boardShape struct {
+	Kind        int        `json:"kind"`
+	Stroke      color.RGBA `json:"stroke"`
+	StrokeWidth float32    `json:"strokeWidth"`
+	Fill        color.RGBA `json:"fill"`
+	FlipX       bool       `json:"flipX,omitempty"`
+	FlipY       bool       `json:"flipY,omitempty"`
+	StartID     int        `json:"startID,omitempty"`
+	EndID       int        `json:"endID,omitempty"`
+}
+
+type boardMeta struct {
+	Name    string      `json:"name"`
+	Width   int         `json:"width"`
+	Height  int         `json:"height"`
+	Size    int64       `json:"size"`
+	Format  string      `json:"format"`
+	ModTime time.Time   `json:"modTime"`
+	EXIF    [][2]string `json:"exif,omitempty"`
+}
+
+func (n *Note) toBoard() *boardNote {
+	if n == nil {
+		return nil
+	}
+	return &boardNote{
+		Text:   n.text,
+		Size:   n.size,
+		Color:  n.color,
+		Sticky: n.sticky,
+	}
+}
+
+func (n *boardNote) toNote() *Note {
+	if n == nil {
+		return nil
+	}
+	return &Note{
+		text:   n.Text,
+		size:   n.Size,
+		color:  n.Color,
+		sticky: n.Sticky,
+	}
+}
+
+func (s *Shape) toBoard() *boardShape {
+	if s == nil {
+		return nil
+	}
+	return &boardShape{
+		Kind:        s.kind,
+		Stroke:      s.stroke,
+		StrokeWidth: s.strokeWidth,
+		Fill:        s.fill,
+		FlipX:       s.flipX,
+		FlipY:       s.flipY,
+		StartID:     s.startID,
+		EndID:       s.endID,
+	}
+}
+
+func (s *boardShape) toShape() *Shape {
+	if s == nil {
+		return nil
+	}
+	return &Shape{
+		kind:        s.Kind,
+		stroke:      s.Stroke,
+		strokeWidth: s.StrokeWidth,
+		fill:        s.Fill,
+		flipX:       s.FlipX,
+		flipY:       s.FlipY,
+		startID:     s.StartID,
+		endID:       s.EndID,
+	}
+}
+
+func (m *Metadata) toBoard() *boardMeta {
+	if m == nil {
+		return nil
+	}
+	return &boardMeta{
+		Name:    m.name,
+		Width:   m.width,
+		Height:  m.height,
+		Size:    m.size,
+		Format:  m.format,
+		ModTime: m.modTime,
+		EXIF:    m.exif,
+	}
+}
+
+func (m *boardMeta) toMetadata() *Metadata {
+	if m == nil {
+		return nil
+	}
+	return &Metadata{
+		name:    m.Name,
+		width:   m.Width,
+		height:  m.Height,
+		size:    m.Size,
+		format:  m.Format,
+		modTime: m.ModTime,
+		exif:    m.EXIF,
+	}
+}
+
+// Pixels have to be read on the game goroutine, encoding happens later
+func readImage(img *ebiten.Image) *image.RGBA {
+	rgba := image.NewRGBA(img.Bounds())
+	img.ReadPixels(rgba.Pix)
+	return rgba
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (v view) toBoard() boardView {
+	return boardView{v.zoom, v.x, v.y}
+}
+
+// Boards from before views were saved are at 100%
+func (v *boardView) toView() view {
+	if v == nil || v.Zoom <= 0 {
+		return view{zoom: 1}
+	}
+	return view{v.Zoom, v.X, v.Y}
+}
+
+func (g *Game) saveAction(selectedIndex int) {
+	g.finishViewAnimation()
+	current := g.view.toBoard()
+	viewpoints := map[int]boardView{}
+	for n, v := range g.viewpoints {
+		viewpoints[n] = v.toBoard()
+	}
+
+	var visages []boardVisage
+	var images []*image.RGBA
+	var frames [][]*image.RGBA
+	sharedIDs := map[*sharedImage]int{}
+	for _, v := range g.visages {
+		if v.loading != nil { // Still importing
+			continue
+		}
+
+		i := len(visages)
+		images = append(images, nil)
+		frames = append(frames, nil)
+		visages = append(visages, boardVisage{
+			ID:      v.id,
+			X:       v.x,
+			Y:       v.y,
+			W:       v.w,
+			H:       v.h,
+			Note:    v.note.toBoard(),
+			Shape:   v.shape.toBoard(),
+			Caption: v.caption.toBoard(),
+			Meta:    v.meta.toBoard(),
+			Linked:  v.linked,
+
+			Locked:       v.locked,
+			Hidden:       v.hidden,
+			Transparency: v.transparency,
+			Rotation:     v.rotation,
+			FlipX:        v.flipX,
+			FlipY:        v.flipY,
+		})
+		if id, ok := sharedIDs[v.shared]; ok { // Saved once with the first visage
+			visages[i].SharedID = id
+		} else if v.shared != nil {
+			sharedIDs[v.shared] = v.id
+		}
+
+		if visages[i].SharedID != 0 {
+			continue
+		} else if v.anim != nil {
+			for _, frame := range v.anim.frames {
+				frames[i] = append(frames[i], readImage(frame))
+			}
+			visages[i].Delays = v.anim.delays
+		} else if v.svg != nil {
+			visages[i].SVG = v.svg.data
+		} else if v.source != nil { // The proxy is made again on load
+			visages[i].Image = v.source.data
+			visages[i].Orientation = v.source.orientation
+		} else if v.image != nil {
+			images[i] = g.readVisageImage(v)
+		}
+	}
+
+	go func() {
+		for i, img := range images {
+			if img == nil {
+				continue
+			}
+			data, err := encodePNG(img)
+			if err != nil {
+				g.notify(severityError, "Failed to encode the board: %v", err)
+				return
+			}
+			visages[i].Image = data
+		}
+		for i := range frames {
+			for _, frame := range frames[i] {
+				data, err := encodePNG(frame)
+				if err != nil {
+					g.notify(severityError, "Failed to encode the board: %v", err)
+					return
+				}
+				visages[i].Frames = append(visages[i].Frames, data)
+			}
+		}
+
+		data, err := json.Marshal(boardFile{
+			Version:    boardVersion,
+			Visages:    visages,
+			View:       &current,
+			Viewpoints: viewpoints,
+		})
+		if err != nil {
+			g.notify(severityError, "Failed to encode the board: %v", err)
+			return
+		}
+		if err := writeFile(boardName, data); err != nil {
+			g.notify(severityError, "Failed to save the board: %v", err)
+			return
+		}
+		g.notify(severityInfo, "Saved %s", boardName)
+	}()
+}
+
+// Board decoded by the dropped files goroutine, swapped in by applyBoard
+type loadedBoard struct {
+	visages    []Visage
+	nextID     int
+	view       view
+	viewpoints map[int]view
+}
+
+// Decodes the board file, called from the dropped files goroutine
+func (g *Game) loadBoard(r io.Reader) *loadedBoard {
+	var board boardFile
+	if err := json.NewDecoder(r).Decode(&board); err != nil {
+		g.notify(severityError, "Failed to decode the board file: %v", err)
+		return nil
+	}
+
+	g.m.Lock()
+	limit := g.proxyLimit
+	g.m.Unlock()
+
+	saved := board.View.toView()
+
+	var visages []Visage
+	var shared [][2]int // Index and the ID of the visage it shares the image of
+	nextID := 0
+	for _, bv := range board.Visages {
+		v := Visage{
+			id:      bv.ID,
+			x:       bv.X,
+			y:       bv.Y,
+			w:       bv.W,
+			h:       bv.H,
+			note:    bv.Note.toNote(),
+			shape:   bv.Shape.toShape(),
+			caption: bv.Caption.toNote(),
+			meta:    bv.Meta.toMetadata(),
+			linked:  bv.Linked,
+
+			locked:       bv.Locked,
+			hidden:       bv.Hidden,
+			transparency: bv.Transparency,
+			rotation:     bv.Rotation,
+			flipX:        bv.FlipX,
+			flipY:        bv.FlipY,
+		}
+		if v.id > nextID {
+			nextID = v.id
+		}
+		if len(bv.Frames) > 0 {
+			anim, err := loadBoardAnimation(bv)
+			if err != nil {
+				g.notify(severityWarning, "Skipped a board animation: %v", err)
+				continue
+			}
+			v.anim = anim
+			v.image = v.anim.frames[0]
+		} else if len(bv.SVG) > 0 {
+			svg, err := parseSVG(bv.SVG)
+			if err != nil {
+				g.notify(severityWarning, "Skipped a board SVG: %v", err)
+				continue
+			}
+			v.svg = svg
+			w, h := v.unturned()
+			v.image = svg.render(int(float64(w)*saved.zoom), int(float64(h)*saved.zoom)) // At its size on screen
+		} else if bv.SharedID != 0 {
+			shared = append(shared, [2]int{len(visages), bv.SharedID})
+		} else if v.note == nil && v.shape == nil {
+			img, _, err := image.Decode(bytes.NewReader(bv.Image))
+			if err != nil {
+				g.notify(severityWarning, "Skipped a board image: %v", err)
+				continue
+			}
+			source := &imageSource{
+				data:        bv.Image,
+				orientation: bv.Orientation,
+			}
+			v.image, v.source = source.proxy(img, limit)
+		}
+		visages = append(visages, v)
+	}
+
+	for _, s := range shared {
+		v := &visages[s[0]]
+		for i := range visages {
+			if w := &visages[i]; w.id == s[1] && w.image != nil && w.anim == nil {
+				if w.shared == nil {
+					w.shared = &sharedImage{refs: 1}
+				}
+				w.shared.refs++
+				v.image, v.shared = w.image, w.shared
+				v.svg, v.source = w.svg.clone(), w.source.clone()
+				break
+			}
+		}
+	}
+	for i := len(visages) - 1; i >= 0; i-- { // The image it shared failed to load
+		if visages[i].image == nil && visages[i].note == nil && visages[i].shape == nil {
+			visages = append(visages[:i], visages[i+1:]...)
+		}
+	}
+
+	loaded := &loadedBoard{visages: visages, nextID: nextID, view: saved, viewpoints: map[int]view{}}
+	for n, v := range board.Viewpoints {
+		loaded.viewpoints[n] = v.toView()
+	}
+	return loaded
+}
+
+// Replaces the current board on the game goroutine
+func (g *Game) applyBoard(b *loadedBoard) {
+	g.m.Lock()
+	for _, job := range g.imports { // Their ids may be taken by the board
+		job.cancelled.Store(true)
+	}
+	g.imports = nil
+	for _, v := range g.visages {
+		releaseVisage(v)
+	}
+	g.visages = b.visages
+	g.nextID = b.nextID
+	g.markDirty()
+	g.selected = false
+	g.erasingToggle = false
+	g.editNote = nil
+	g.group = nil
+	g.menu = nil
+	g.viewAnim = nil
+	g.view = b.view
+	g.viewpoints = b.viewpoints
+	g.m.Unlock()
+	g.notify(severityInfo, "Loaded %d visages", len(b.visages))
+}
+
+func loadBoardAnimation(bv boardVisage) (*Animation, error) {
+	if len(bv.Delays) != len(bv.Frames) {
+		return nil, fmt.Errorf("%d delays for %d frames", len(bv.Delays), len(bv.Frames))
+	}
+
+	anim := &Animation{
+		delays:  bv.Delays,
+		playing: true,
+		speed:   1,
+	}
+	for _, data := range bv.Frames {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		anim.frames = append(anim.frames, ebiten.NewImageFromImage(img))
+	}
+	return anim, nil
+}
+
+// Bounds of everything drawn for the visage including its caption
+func (g *Game) visageBounds(v Visage) image.Rectangle {
+	r := image.Rect(v.x, v.y, v.x+v.w, v.y+v.h)
+	if v.shape != nil {
+		r = r.Inset(-shapeOverhang(v.shape))
+	}
+	if v.caption != nil {
+		cw := int(g.captionWidth(v))
+		r = r.Union(image.Rect(v.x+v.w/2-cw/2, v.y+v.h, v.x+v.w/2+cw/2, v.y+v.h+g.captionHeight(v)))
+	}
+	return r
+}
+
+func (g *Game) exportAction(selectedIndex int) {
+	if len(g.visages) == 0 {
+		return
+	}
+
+	bounds := g.visibleBounds() // Placeholders and hidden visages are left out
+	if bounds.Empty() {
+		return
+	}
+
+	saved := g.view
+	g.setView(view{1, float64(-bounds.Min.X), float64(-bounds.Min.Y)}) // The board at its actual size
+	defer g.setView(saved)
+
+	dst := ebiten.NewImage(bounds.Dx(), bounds.Dy())
+	defer dst.Deallocate()
+	for i := range g.visages {
+		if g.visages[i].loading != nil {
+			continue
+		}
+		evicted := g.visages[i].evicted != nil // Brought back only while drawn to stay in the budget
+		g.restoreTexture(i)
+
+		g.drawVisage(dst, g.screenVisage(g.visages[i]))
+		if evicted {
+			g.evictTexture(i)
+		}
+	}
+	g.writePNG(readImage(dst), exportName)
+}
+
+// Encodes and writes the export in the background
+func (g *Game) writePNG(img *image.RGBA, name string) {
+	go func() {
+		data, err := encodePNG(img)
+		if err != nil {
+			g.notify(severityError, "Failed to encode the export: %v", err)
+			return
+		}
+		if err := writeFile(name, data); err != nil {
+			g.notify(severityError, "Failed to export %s: %v", name, err)
+			return
+		}
+		g.notify(severityInfo, "Exported %s", name)
+	}()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Slider under the visage while erasing, value is clamped to min and max
+type brushSlider struct {
+	label    string
+	value    *int
+	min, max int
+}
+
+const (
+	sliderSpacing     = 24
+	sliderMouseOffset = 14
+	sliderLabelOffset = 20
+	brushHardnessMax  = 100
+	brushOpacityMax   = 100
+	brushSpacingMin   = 1
+	brushSpacingMax   = 100
+	brushSizeStep     = 5
+)
+
+func (g *Game) brushSliders() []brushSlider {
+	return []brushSlider{
+		{"Size", &g.sliderValue, sliderMin, sliderMax},
+		{"Hardness", &g.brushHardness, 0, brushHardnessMax},
+		{"Opacity", &g.brushOpacity, 0, brushOpacityMax},
+		{"Spacing", &g.brushSpacing, brushSpacingMin, brushSpacingMax},
+	}
+}
+
+func (s brushSlider) set(value int) {
+	*s.value = value
+	if *s.value < s.min {
+		*s.value = s.min
+	} else if *s.value > s.max {
+		*s.value = s.max
+	}
+}
+
+func sliderLeft(v Visage) int {
+	return v.x + (v.w / 2) - (sliderWidth / 2)
+}
+
+func sliderTop(v Visage, i int) int {
+	return v.y + v.h + sliderYOffset + i*sliderSpacing
+}
+
+func (s brushSlider) knobX(v Visage) float32 {
+	return float32(sliderLeft(v)) + float32((*s.value-s.min)*sliderWidth)/float32(s.max-s.min)
+}
+
+func (s brushSlider) setFromX(v Visage, x int) {
+	s.set(s.min + int(math.Round(float64((x-sliderLeft(v))*(s.max-s.min))/sliderWidth)))
+}
+
+// Returns the slider under the cursor, or -1
+func (g *Game) sliderAt(v Visage, x, y int) int {
+	left := sliderLeft(v)
+	for i := range g.brushSliders() {
+		top := sliderTop(v, i)
+		if x >= left-sliderMouseOffset && x <= left+sliderWidth+sliderMouseOffset && y >= top-sliderMouseOffset/2 && y <= top+sliderHeight+sliderMouseOffset/2 {
+			return i
+		}
+	}
+	return -1
+}
+
+// The eraser area reaches further below the visage to cover all of the sliders
+func (g *Game) outsideEraserArea(v Visage, x, y int) bool {
+	bottom := sliderYOffset + (len(g.brushSliders())-1)*sliderSpacing + erasingOOBOffset/2
+	return x < v.x-erasingOOBOffset || x > v.x+v.w+erasingOOBOffset || y < v.y-erasingOOBOffset || y > v.y+v.h+bottom
+}
+
+func (g *Game) drawBrushSliders(screen *ebiten.Image, v Visage) {
+	colorWhite := g.theme().handleOutline
+	colorBlack := g.theme().handle
+	for i, s := range g.brushSliders() {
+		top := float32(sliderTop(v, i))
+		vector.DrawFilledRect(screen, float32(sliderLeft(v)), top, sliderWidth, sliderHeight, colorBlack, false)
+		vector.DrawFilledCircle(screen, s.knobX(v), top+4, 12, colorBlack, false)
+		vector.DrawFilledCircle(screen, s.knobX(v), top+4, 10, colorWhite, false)
+		ebitenutil.DebugPrintAt(screen, fmt.Sprintf("%s: %d", s.label, *s.value), sliderLeft(v)+sliderWidth+sliderLabelOffset, int(top)-4)
+	}
+}
+
+// Eraser strength at a distance from the dab center in 0-1 of the radius
+func brushFalloff(d, hardness float64) float64 {
+	if d >= 1 {
+		return 0
+	}
+	if d <= hardness {
+		return 1
+	}
+	t := (d - hardness) / (1 - hardness)
+	return 1 - t*t*(3-2*t)
+}
+
+// Reads the pixels once per stroke, dabs are applied to the copy
+func (g *Game) startBrushStroke(index int) {
+	g.pushUndo(index)
+	v := g.visages[index]
+	g.brushImage = v.image
+	g.brushPixels = make([]byte, 4*v.image.Bounds().Dx()*v.image.Bounds().Dy())
+	v.image.ReadPixels(g.brushPixels)
+	g.brushCarry = 0
+}
+
+func (g *Game) endBrushStroke() {
+	g.brushImage = nil
+	g.brushPixels = nil
+}
+
+// Erases dabs along the segment in image pixels, spaced by a share of the brush size
+func (g *Game) brushStroke(v *Visage, x0, y0, x1, y1 int) {
+	if g.brushImage != v.image {
+		return
+	}
+
+	w, h := v.image.Bounds().Dx(), v.image.Bounds().Dy()
+	uw, _ := v.unturned()
+	radius := float64(g.sliderValue) / 2 * float64(w) / float64(uw)
+	spacing := math.Max(1, 2*radius*float64(g.brushSpacing)/100)
+	strength := float64(g.brushOpacity) / 100 * penPressure()
+	hardness := float64(g.brushHardness) / 100
+
+	dirty := image.Rectangle{}
+	length := math.Hypot(float64(x1-x0), float64(y1-y0))
+	if length == 0 {
+		if g.brushCarry == 0 { // First dab of the stroke
+			dirty = g.eraseDab(w, h, float64(x1), float64(y1), radius, strength, hardness)
+			g.brushCarry = spacing
+		}
+	} else {
+		d := g.brushCarry
+		for ; d <= length; d += spacing {
+			cx := float64(x0) + d/length*float64(x1-x0)
+			cy := float64(y0) + d/length*float64(y1-y0)
+			dirty = dirty.Union(g.eraseDab(w, h, cx, cy, radius, strength, hardness))
+		}
+		g.brushCarry = d - length
+	}
+
+	if dirty.Empty() {
+		return
+	}
+	buf := make([]byte, 0, 4*dirty.Dx()*dirty.Dy())
+	for y := dirty.Min.Y; y < dirty.Max.Y; y++ {
+		buf = append(buf, g.brushPixels[4*(y*w+dirty.Min.X):4*(y*w+dirty.Max.X)]...)
+	}
+	v.image.SubImage(dirty).(*ebiten.Image).WritePixels(buf)
+}
+
+// Returns the area of the image the dab changed
+func (g *Game) eraseDab(w, h int, cx, cy, radius, strength, hardness float64) image.Rectangle {
+	r := image.Rect(int(math.Floor(cx-radius)), int(math.Floor(cy-radius)), int(math.Ceil(cx+radius))+1, int(math.Ceil(cy+radius))+1).Intersect(image.Rect(0, 0, w, h))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy) / radius
+			if weight := brushFalloff(d, hardness); weight > 0 {
+				scaleAlpha(g.brushPixels, 4*(y*w+x), 1-strength*weight)
+			}
+		}
+	}
+	return r
+}
+
+func (g *Game) brushLargerAction(selectedIndex int) {
+	g.updateSliderValue(g.sliderValue + brushSizeStep)
+}
+
+func (g *Game) brushSmallerAction(selectedIndex int) {
+	g.updateSliderValue(g.sliderValue - brushSizeStep)
+}
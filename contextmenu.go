@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Menu opened by a right click that did not pan, items with an empty label are separators
+type contextMenu struct {
+	x, y  int
+	items []menuItem
+	hover int
+}
+
+type menuItem struct {
+	label   string
+	keys    string
+	enabled bool
+	run     func()
+}
+
+const (
+	menuWidth          = 260
+	menuRowHeight      = 18
+	menuSeparator      = 7
+	menuPadding        = 4
+	menuDragThreshold  = 4 // Pixels the right button may move before the click becomes a pan
+	transparencyStep   = 25
+	transparencyMax    = 75
+	exportImageDefault = "image.png"
+)
+
+var (
+	colorMenuHover    = color.RGBA{0, 90, 200, 255}
+	colorMenuDisabled = color.RGBA{40, 40, 40, 160}
+)
+
+// Items running a registered action show its keys and follow its enabled state
+func (g *Game) actionItem(name string) menuItem {
+	a := g.actionByName(name)
+	return menuItem{
+		label:   a.description,
+		keys:    a.keys(),
+		enabled: a.isEnabled(),
+		run: func() {
+			g.runAction(a)
+		},
+	}
+}
+
+func (g *Game) labelledItem(name, label string) menuItem {
+	item := g.actionItem(name)
+	item.label = label
+	return item
+}
+
+// The visage under the cursor gets selected and its menu opened, the canvas menu otherwise
+func (g *Game) openContextMenu(x, y int) {
+	hit := -1
+	bx, by := g.toBoard(x, y)
+	hits := g.visagesAt(bx, by)
+	for j := len(hits) - 1; j >= 0; j-- {
+		if g.hitVisage(g.visages[hits[j]], bx, by) {
+			hit = hits[j]
+			break
+		}
+	}
+
+	if (g.erasingToggle || g.selectTool != selectNone) && (!g.selected || hit != g.selectedIndex) {
+		hit = -1 // The pixel tools stay on the selected image
+	}
+
+	var items []menuItem
+	if hit >= 0 {
+		g.selected = true
+		g.selectedIndex = hit
+		v := g.visages[hit]
+
+		lock, opacity := "Lock", "Opacity"
+		if v.locked {
+			lock = "Unlock"
+		}
+		if v.image != nil {
+			opacity = fmt.Sprintf("Opacity %d%%", 100-v.transparency)
+		}
+		items = []menuItem{
+			g.actionItem("clipboardCopy"),
+			g.actionItem("copy"),
+			g.actionItem("instance"),
+			g.actionItem("move"),
+			{},
+			g.actionItem("flip"),
+			g.actionItem("rotate"),
+			g.actionItem("erase"),
+			g.actionItem("caption"),
+			g.actionItem("info"),
+			{},
+			g.labelledItem("lock", lock),
+			g.actionItem("hide"),
+			g.labelledItem("opacity", opacity),
+			g.actionItem("reset"),
+			g.actionItem("exportImage"),
+			{},
+			g.actionItem("delete"),
+		}
+	} else {
+		paste, px, py := g.actionItem("paste"), x, y
+		paste.run = func() {
+			g.pasteAt(px, py)
+		}
+		items = []menuItem{
+			paste,
+			g.actionItem("import"),
+			g.actionItem("selectAll"),
+			g.actionItem("fitView"),
+		}
+		if n := g.hiddenCount(); n > 0 {
+			items = append(items, g.labelledItem("showHidden", fmt.Sprintf("Show hidden (%d)", n)))
+		}
+		if n := len(g.imports); n > 0 {
+			items = append(items, g.labelledItem("cancelImports", fmt.Sprintf("Cancel imports (%d)", n)))
+		}
+	}
+
+	// Flips to the other side of the cursor near the screen edges
+	x, y = g.uiPoint(x, y)
+	h := menuHeight(items)
+	if x+menuWidth > g.uiWidth {
+		x -= menuWidth
+	}
+	if y+h > g.uiHeight {
+		y = int(math.Max(0, float64(y-h)))
+	}
+	g.menu = &contextMenu{x: x, y: y, items: items, hover: -1}
+}
+
+func menuHeight(items []menuItem) int {
+	h := 2 * menuPadding
+	for _, item := range items {
+		if item.label == "" {
+			h += menuSeparator
+		} else {
+			h += menuRowHeight
+		}
+	}
+	return h
+}
+
+// Item under the cursor, -1 outside the menu or on a separator
+func (m *contextMenu) itemAt(x, y int) int {
+	if x < m.x || x >= m.x+menuWidth {
+		return -1
+	}
+	top := m.y + menuPadding
+	for i, item := range m.items {
+		h := menuRowHeight
+		if item.label == "" {
+			h = menuSeparator
+		}
+		if y >= top && y < top+h && item.label != "" {
+			return i
+		}
+		top += h
+	}
+	return -1
+}
+
+// The menu takes the mouse while open, any click outside closes it
+func (g *Game) handleContextMenu(x, y int) {
+	m := g.menu
+	m.hover = m.itemAt(x, y)
+
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyEscape):
+		g.menu = nil
+	case inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft):
+		g.menu = nil
+		if m.hover >= 0 && m.items[m.hover].enabled {
+			m.items[m.hover].run()
+		}
+		g.clicking = true // The press must not start a drag once the menu is gone
+	case inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight):
+		g.menu = nil
+	}
+}
+
+func (g *Game) drawContextMenu(screen *ebiten.Image) {
+	m := g.menu
+	items := m.items
+	vector.DrawFilledRect(screen, float32(m.x), float32(m.y), menuWidth, float32(menuHeight(items)), g.theme().panel, false)
+
+	y := m.y + menuPadding
+	for i, item := range items {
+		if item.label == "" {
+			vector.StrokeLine(screen, float32(m.x+menuPadding), float32(y+menuSeparator/2), float32(m.x+menuWidth-menuPadding), float32(y+menuSeparator/2), 1, color.RGBA{90, 90, 90, 255}, false)
+			y += menuSeparator
+			continue
+		}
+
+		if i == m.hover && item.enabled {
+			vector.DrawFilledRect(screen, float32(m.x), float32(y), menuWidth, menuRowHeight, colorMenuHover, false)
+		}
+		ebitenutil.DebugPrintAt(screen, item.label, m.x+2*menuPadding, y+1)
+		ebitenutil.DebugPrintAt(screen, item.keys, m.x+menuWidth-2*menuPadding-6*len(item.keys), y+1) // The debug font is 6 pixels wide
+		if !item.enabled {                                                                            // The debug font is always white, disabled rows are dimmed over it
+			vector.DrawFilledRect(screen, float32(m.x), float32(y), menuWidth, menuRowHeight, colorMenuDisabled, false)
+		}
+		y += menuRowHeight
+	}
+}
+
+func (g *Game) hiddenCount() int {
+	n := 0
+	for _, v := range g.visages {
+		if v.hidden {
+			n++
+		}
+	}
+	return n
+}
+
+// Size of the image before any resizing, the proxy is measured at its full resolution
+func naturalSize(v Visage) (int, int) {
+	w, h := v.image.Bounds().Dx(), v.image.Bounds().Dy()
+	switch {
+	case v.svg != nil:
+		w, h = v.svg.size()
+	case v.source != nil && v.meta != nil:
+		scale := math.Max(float64(v.meta.width), float64(v.meta.height)) / math.Max(float64(w), float64(h))
+		w, h = int(math.Round(float64(w)*scale)), int(math.Round(float64(h)*scale))
+	}
+	if v.rotation%180 != 0 {
+		return h, w
+	}
+	return w, h
+}
+
+func (g *Game) lockAction(selectedIndex int) {
+	if len(g.visages) == 0 || !g.selected {
+		return
+	}
+	v := &g.visages[selectedIndex]
+	v.locked = !v.locked
+}
+
+func (g *Game) hideAction(selectedIndex int) {
+	if len(g.visages) == 0 || !g.selected || g.erasingToggle || g.selectTool != selectNone {
+		return
+	}
+	g.visages[selectedIndex].hidden = true
+	g.selected = false
+	g.notify(severityInfo, "Hidden, right click the canvas to show it again")
+}
+
+func (g *Game) showHiddenAction(selectedIndex int) {
+	for i := range g.visages {
+		g.visages[i].hidden = false
+	}
+}
+
+// Steps the selected image through the opacity levels and back to opaque
+func (g *Game) opacityAction(selectedIndex int) {
+	if len(g.visages) == 0 || !g.selected || g.visages[selectedIndex].image == nil {
+		return
+	}
+	v := &g.visages[selectedIndex]
+	v.transparency += transparencyStep
+	if v.transparency > transparencyMax {
+		v.transparency = 0
+	}
+}
+
+// Back to the natural size and fully opaque
+func (g *Game) resetAction(selectedIndex int) {
+	if len(g.visages) == 0 || !g.selected || g.visages[selectedIndex].image == nil || g.visages[selectedIndex].locked {
+		return
+	}
+	v := &g.visages[selectedIndex]
+	v.w, v.h = naturalSize(*v)
+	v.transparency = 0
+	g.refreshVectorImage(selectedIndex)
+	g.refreshProxy(selectedIndex)
+}
+
+// Exports the selected image on its own at its current size
+func (g *Game) exportImageAction(selectedIndex int) {
+	if len(g.visages) == 0 || !g.selected || g.visages[selectedIndex].image == nil || g.visages[selectedIndex].loading != nil {
+		return
+	}
+
+	g.restoreTexture(selectedIndex)
+	v := g.visages[selectedIndex]
+	v.x, v.y, v.caption = 0, 0, nil
+	dst := ebiten.NewImage(v.w, v.h)
+	defer dst.Deallocate()
+	g.drawVisage(dst, v)
+
+	name := exportImageDefault
+	if v.meta != nil && v.meta.name != "" {
+		name = strings.TrimSuffix(v.meta.name, filepath.Ext(v.meta.name)) + ".png"
+	}
+	g.writePNG(readImage(dst), name)
+}
+
+// Remembers the selected visage, pasting copies it as it is by then
+func (g *Game) clipboardCopyAction(selectedIndex int) {
+	if len(g.visages) == 0 || !g.selected || g.visages[selectedIndex].loading != nil {
+		return
+	}
+	g.clipboardID = g.visages[selectedIndex].id
+}
+
+func (g *Game) pasteAction(selectedIndex int) {
+	if g.clipboardID == 0 || g.erasingToggle {
+		return
+	}
+	g.pasteAt(ebiten.CursorPosition())
+}
+
+// Pastes a copy of the visage on the clipboard with its top left corner at the screen point
+func (g *Game) pasteAt(x, y int) {
+	i := g.visageIndexByID(g.clipboardID)
+	if i < 0 {
+		g.notify(severityWarning, "The copied visage was deleted")
+		g.clipboardID = 0
+		return
+	}
+
+	v := g.cloneVisage(i)
+	v.x, v.y = g.toBoard(x, y)
+	v.hidden = false
+	g.visages = append(g.visages, v)
+	g.selected = true
+	g.selectedIndex = len(g.visages) - 1
+}
+
+func (g *Game) importAction(selectedIndex int) {
+	if err := pickFiles(func(files fs.FS) {
+		g.importFiles(files)
+	}); err != nil {
+		g.notify(severityInfo, "%v", err)
+	}
+}
+
+// Selects every visible visage, dragging one of them moves them all
+func (g *Game) selectAllAction(selectedIndex int) {
+	if len(g.visages) == 0 || g.erasingToggle || g.selectTool != selectNone {
+		return
+	}
+	g.group = map[int]bool{}
+	for i, v := range g.visages {
+		if !v.hidden {
+			g.group[v.id] = true
+			g.selected = true
+			g.selectedIndex = i
+		}
+	}
+}
+
+// Moves the other visages of the group along with the one being dragged
+func (g *Game) dragGroup(dx, dy int) {
+	for i := range g.visages {
+		if v := &g.visages[i]; i != g.selectedIndex && g.group[v.id] && !v.locked {
+			v.x += dx
+			v.y += dy
+		}
+	}
+}
+
+func (g *Game) visibleBounds() image.Rectangle {
+	bounds := image.Rectangle{}
+	for _, v := range g.visages {
+		if !v.hidden && v.loading == nil {
+			bounds = bounds.Union(g.visageBounds(v))
+		}
+	}
+	return bounds
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// Asks Draw for a new frame, the screen keeps the last one until then
+func (g *Game) markDirty() {
+	g.dirty.Store(true)
+}
+
+// Whatever the user changes comes in through the input
+func (g *Game) updateIdle(x, y int) {
+	if g.inputActive(x, y) {
+		g.markDirty()
+	}
+	g.cursorX, g.cursorY = x, y
+}
+
+func (g *Game) inputActive(x, y int) bool {
+	if x != g.cursorX || y != g.cursorY {
+		return true
+	}
+	if wx, wy := ebiten.Wheel(); wx != 0 || wy != 0 {
+		return true
+	}
+	for b := ebiten.MouseButton0; b <= ebiten.MouseButtonMax; b++ {
+		if ebiten.IsMouseButtonPressed(b) || inpututil.IsMouseButtonJustReleased(b) {
+			return true
+		}
+	}
+	if len(inpututil.AppendPressedKeys(nil)) > 0 || len(inpututil.AppendJustReleasedKeys(nil)) > 0 {
+		return true
+	}
+	return len(ebiten.AppendTouchIDs(nil)) > 0 || len(inpututil.AppendJustReleasedTouchIDs(nil)) > 0
+}
+
+// Keeps redrawing every frame while on so the counters stay live
+func (g *Game) fpsDebugAction(selectedIndex int) {
+	g.settings.FPSDebug = !g.settings.FPSDebug
+	g.saveSettings()
+}
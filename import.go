@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+	"golang.org/x/image/draw"
+)
+
+// Dropped file being decoded by a worker, shown as a placeholder visage until done
+type importJob struct {
+	id        int
+	files     fs.FS
+	path      string
+	info      fs.FileInfo
+	limit     int // Proxy size when the import was queued
+	stage     atomic.Int32
+	read      atomic.Int64
+	cancelled atomic.Bool
+	done      bool   // Guarded by g.m like result
+	result    Visage // Image is nil when the import failed
+}
+
+// Found by the goroutine walking the dropped files, put on the board by updateImports
+type droppedFile struct {
+	job   *importJob
+	board *loadedBoard
+}
+
+// Compressed file behind a proxy, decoded again when the full resolution is needed
+type imageSource struct {
+	data        []byte
+	orientation int
+}
+
+const (
+	importWaiting = iota
+	importReading
+	importDecoding
+)
+
+const (
+	importWorkers        = 4
+	droppedQueue         = 256 // Files found but not yet placed
+	importCascadeSteps   = 8
+	placeholderWidth     = 200
+	placeholderHeight    = 120
+	placeholderPadding   = 10
+	placeholderBarHeight = 8
+	proxyLimitDefault    = 2048 // Longest side in pixels, larger images get a proxy
+)
+
+var errImportCancelled = errors.New("import cancelled")
+
+// Counts the bytes read for the progress bar and stops once the import is cancelled
+type progressReader struct {
+	r   io.Reader
+	job *importJob
+}
+
+func (p progressReader) Read(b []byte) (int, error) {
+	if p.job.cancelled.Load() {
+		return 0, errImportCancelled
+	}
+	n, err := p.r.Read(b)
+	p.job.read.Add(int64(n))
+	return n, err
+}
+
+// Walks the dropped files and queues the images and boards for the game goroutine
+func (g *Game) importFiles(files fs.FS) {
+	if err := fs.WalkDir(files, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		log.Printf("Name: %s, Size: %d, IsDir: %t, ModTime: %v", fi.Name(), fi.Size(), fi.IsDir(), fi.ModTime())
+
+		if fi.IsDir() {
+			return nil
+		}
+
+		if strings.EqualFold(filepath.Ext(path), boardExt) {
+			f, err := files.Open(path)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = f.Close()
+			}()
+			if board := g.loadBoard(f); board != nil {
+				g.dropped <- droppedFile{board: board}
+			}
+			return nil
+		}
+
+		g.dropped <- droppedFile{job: &importJob{files: files, path: path, info: fi}}
+		return nil
+	}); err != nil {
+		g.notify(severityError, "Failed to import the dropped files: %v", err)
+	}
+}
+
+// Places the placeholder on the game goroutine and starts decoding the file
+func (g *Game) queueImport(job *importJob) {
+	job.id = g.newID()
+	g.m.Lock()
+	defer g.m.Unlock()
+	job.limit = g.proxyLimit
+	offset := len(g.imports) % importCascadeSteps * g.settings.ImportCascade
+	x, y := g.toBoard(g.settings.ImportX+offset, g.settings.ImportY+offset)
+	g.visages = append(g.visages, Visage{
+		id:      job.id,
+		x:       x,
+		y:       y,
+		w:       placeholderWidth,
+		h:       placeholderHeight,
+		loading: job,
+	})
+	g.imports = append(g.imports, job)
+	g.markDirty()
+
+	go g.runImport(job)
+}
+
+// Waits for a free worker slot so only a few files are decoded at once
+func (g *Game) runImport(job *importJob) {
+	g.importSlots <- struct{}{}
+	defer func() {
+		<-g.importSlots
+	}()
+
+	v, err := decodeImport(job.files, job.path, job)
+	if err != nil && !errors.Is(err, errImportCancelled) {
+		g.notify(severityError, "Failed to import %s: %v", job.info.Name(), err)
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+	if err == nil {
+		job.result = v
+	}
+	job.done = true
+}
+
+func decodeImport(files fs.FS, path string, job *importJob) (Visage, error) {
+	if job.cancelled.Load() {
+		return Visage{}, errImportCancelled
+	}
+
+	job.stage.Store(importReading)
+	f, err := files.Open(path)
+	if err != nil {
+		return Visage{}, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	data, err := io.ReadAll(progressReader{f, job})
+	if err != nil {
+		return Visage{}, err
+	}
+
+	job.stage.Store(importDecoding)
+	v := Visage{
+		meta: &Metadata{
+			name:    job.info.Name(),
+			size:    job.info.Size(),
+			modTime: job.info.ModTime(),
+		},
+	}
+
+	if strings.EqualFold(filepath.Ext(path), svgExt) {
+		v.svg, err = parseSVG(data)
+		if err != nil {
+			return Visage{}, err
+		}
+		v.meta.format = "svg"
+		v.meta.width, v.meta.height = v.svg.size()
+		v.image = v.svg.render(v.meta.width, v.meta.height)
+	} else {
+		var img image.Image
+		format := "gif"
+		if bytes.HasPrefix(data, []byte("GIF8")) { // image.Decode only keeps the first frame
+			v.anim, img, err = decodeGIF(data)
+		} else {
+			img, format, err = image.Decode(bytes.NewReader(data))
+		}
+		if err != nil {
+			return Visage{}, err
+		}
+		v.meta.format = format
+
+		if v.anim != nil {
+			v.image = v.anim.frames[0]
+			v.meta.width, v.meta.height = v.image.Bounds().Dx(), v.image.Bounds().Dy()
+		} else {
+			v.meta.width, v.meta.height = img.Bounds().Dx(), img.Bounds().Dy()
+			source := &imageSource{
+				data:        data,
+				orientation: 1,
+			}
+			if format == "jpeg" || format == "tiff" {
+				source.orientation, v.meta.exif = readEXIF(data)
+			}
+			v.image, v.source = source.proxy(img, job.limit)
+		}
+	}
+
+	if job.cancelled.Load() {
+		return Visage{}, errImportCancelled
+	}
+	v.w, v.h = v.image.Bounds().Dx(), v.image.Bounds().Dy()
+	return v, nil
+}
+
+// Places the dropped files and puts finished imports in place of their placeholders
+func (g *Game) updateImports() {
+	for drained := false; !drained; {
+		select {
+		case d := <-g.dropped:
+			if d.board != nil {
+				g.applyBoard(d.board)
+			} else {
+				g.queueImport(d.job)
+			}
+		default:
+			drained = true
+		}
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	imports := g.imports[:0]
+	for _, job := range g.imports {
+		if !job.done && !job.cancelled.Load() {
+			imports = append(imports, job)
+			continue
+		}
+
+		i := g.visageIndexByID(job.id)
+		if i < 0 { // Placeholder was deleted
+			continue
+		}
+		if job.cancelled.Load() || job.result.image == nil {
+			g.removeVisage(i)
+			continue
+		}
+
+		v := &g.visages[i]
+		r := job.result
+		r.id, r.x, r.y, r.caption = v.id, v.x, v.y, v.caption
+		*v = r
+	}
+	if len(g.imports) > 0 { // The placeholders show progress, the last one is cleared too
+		g.markDirty()
+	}
+	g.imports = imports
+}
+
+func (g *Game) removeVisage(index int) {
+	g.visages = append(g.visages[:index], g.visages[index+1:]...)
+	switch {
+	case !g.selected:
+	case g.selectedIndex == index:
+		g.selected = false
+		g.dragging = false
+		g.resizing = false
+	case g.selectedIndex > index:
+		g.selectedIndex--
+	}
+}
+
+// Stops every import still in flight, their placeholders are removed
+func (g *Game) cancelImportsAction(selectedIndex int) {
+	g.m.Lock()
+	defer g.m.Unlock()
+	for _, job := range g.imports {
+		job.cancelled.Store(true)
+	}
+}
+
+func (g *Game) drawPlaceholder(screen *ebiten.Image, v Visage) {
+	job := v.loading
+	x, y, w := float32(v.x), float32(v.y), float32(v.w)
+	vector.DrawFilledRect(screen, x, y, w, float32(v.h), color.RGBA{200, 200, 200, 255}, false)
+
+	name := job.info.Name()
+	if n := (v.w - 2*placeholderPadding) / 6; n > 0 && len(name) > n { // The debug font is 6 pixels wide
+		name = name[:n]
+	}
+	ebitenutil.DebugPrintAt(screen, name, v.x+placeholderPadding, v.y+placeholderPadding)
+
+	status := "Waiting"
+	barX := x + placeholderPadding
+	barY := y + float32(v.h-placeholderPadding-placeholderBarHeight)
+	barW := w - 2*placeholderPadding
+	vector.DrawFilledRect(screen, barX, barY, barW, placeholderBarHeight, color.RGBA{150, 150, 150, 255}, false)
+	switch job.stage.Load() {
+	case importReading:
+		progress := 1.0
+		if job.info.Size() > 0 {
+			progress = math.Min(1, float64(job.read.Load())/float64(job.info.Size()))
+		}
+		status = fmt.Sprintf("Reading %d%%", int(progress*100))
+		vector.DrawFilledRect(screen, barX, barY, barW*float32(progress), placeholderBarHeight, color.RGBA{60, 60, 60, 255}, false)
+	case importDecoding:
+		// Decoding reports no progress, a block sweeps along the bar instead
+		status = "Decoding"
+		t := float32(g.ticks%60) / 60
+		vector.DrawFilledRect(screen, barX+t*barW*3/4, barY, barW/4, placeholderBarHeight, color.RGBA{60, 60, 60, 255}, false)
+	}
+	ebitenutil.DebugPrintAt(screen, status, v.x+placeholderPadding, int(barY)-2*placeholderPadding)
+}
+
+// Downscales images above the limit, the source is kept to decode the full resolution later
+func (s *imageSource) proxy(img image.Image, limit int) (*ebiten.Image, *imageSource) {
+	b := img.Bounds()
+	if limit <= 0 || (b.Dx() <= limit && b.Dy() <= limit) {
+		return s.apply(ebiten.NewImageFromImage(img)), nil
+	}
+
+	scale := float64(limit) / math.Max(float64(b.Dx()), float64(b.Dy()))
+	w := int(math.Max(1, math.Round(float64(b.Dx())*scale)))
+	h := int(math.Max(1, math.Round(float64(b.Dy())*scale)))
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return s.apply(ebiten.NewImageFromImage(dst)), s
+}
+
+func (s *imageSource) apply(img *ebiten.Image) *ebiten.Image {
+	return autoOrient(img, s.orientation)
+}
+
+func (s *imageSource) decode() (*ebiten.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(s.data))
+	if err != nil {
+		return nil, err
+	}
+	return s.apply(ebiten.NewImageFromImage(img)), nil
+}
+
+func (s *imageSource) clone() *imageSource {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	return &c
+}
+
+// Swaps the proxy of the visage for the full resolution before its pixels are edited
+func (g *Game) loadFullResolution(index int) {
+	v := &g.visages[index]
+	if v.source == nil {
+		return
+	}
+
+	img, err := v.source.decode()
+	v.source = nil
+	if err != nil {
+		g.notify(severityError, "Failed to load the full resolution: %v", err)
+		return
+	}
+	g.replaceLinkedImage(index, img)
+}
+
+// Pixel tools work on the full resolution of the selected visage
+func (g *Game) updateFullResolution() {
+	if g.selected && (g.erasingToggle || g.selectTool != selectNone) {
+		g.prepareEdit(g.selectedIndex)
+	}
+}
+
+// Loads the full resolution in the background once the visage is shown larger than its proxy
+func (g *Game) refreshProxy(index int) {
+	v := g.screenVisage(g.visages[index])
+	if v.source == nil {
+		return
+	}
+	if w, h := v.unturned(); w <= v.image.Bounds().Dx() && h <= v.image.Bounds().Dy() {
+		return
+	}
+
+	source, id := v.source, v.id
+	go func() {
+		img, err := source.decode()
+		if err != nil {
+			g.notify(severityError, "Failed to load the full resolution: %v", err)
+			return
+		}
+
+		g.m.Lock()
+		defer g.m.Unlock()
+		if i := g.visageIndexByID(id); i >= 0 && g.visages[i].source == source {
+			g.replaceLinkedImage(i, img) // Every linked instance was showing the proxy
+			g.visages[i].evicted = nil
+			g.visages[i].source = nil
+			g.markDirty()
+		}
+	}()
+}
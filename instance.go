@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Texture shared by duplicates, linked instances keep editing it together
+type sharedImage struct {
+	refs int
+}
+
+// Drops the visage from its share group, true when nobody else uses the texture
+func (v *Visage) detachImage() bool {
+	if v.shared == nil {
+		return true
+	}
+	v.shared.refs--
+	last := v.shared.refs == 0
+	v.shared = nil
+	v.linked = false
+	return last
+}
+
+// Duplicate sharing the texture of the selected visage
+func (g *Game) duplicate(index int, linked bool) Visage {
+	v := &g.visages[index]
+	if v.shared == nil {
+		v.shared = &sharedImage{refs: 1}
+	}
+	v.shared.refs++
+	if linked {
+		v.linked = true
+	}
+
+	return Visage{
+		id:      g.newID(),
+		x:       v.x + 30,
+		y:       v.y + 30,
+		w:       v.w,
+		h:       v.h,
+		image:   v.image,
+		shared:  v.shared,
+		linked:  linked,
+		svg:     v.svg.clone(),
+		meta:    v.meta,
+		source:  v.source.clone(),
+		caption: v.caption.clone(),
+
+		rotation: v.rotation,
+		flipX:    v.flipX,
+		flipY:    v.flipY,
+	}
+}
+
+// Size the texture is stretched to before the quarter turns
+func (v Visage) unturned() (int, int) {
+	if v.rotation%180 != 0 {
+		return v.h, v.w
+	}
+	return v.w, v.h
+}
+
+// Places a texture of the given size flipped and turned in the box of the visage
+func (v Visage) orient(geoM *ebiten.GeoM, w, h int) {
+	uw, uh := v.unturned()
+	sx, sy := float64(uw)/float64(w), float64(uh)/float64(h)
+	if v.flipX {
+		sx = -sx
+	}
+	if v.flipY {
+		sy = -sy
+	}
+	geoM.Translate(-float64(w)/2, -float64(h)/2)
+	geoM.Scale(sx, sy)
+
+	var turn ebiten.GeoM // Exact quarter turns keep the pixels on the grid
+	a := float64(v.rotation) * math.Pi / 180
+	cos, sin := math.Round(math.Cos(a)), math.Round(math.Sin(a))
+	turn.SetElement(0, 0, cos)
+	turn.SetElement(0, 1, -sin)
+	turn.SetElement(1, 0, sin)
+	turn.SetElement(1, 1, cos)
+	geoM.Concat(turn)
+	geoM.Translate(float64(v.x)+float64(v.w)/2, float64(v.y)+float64(v.h)/2)
+}
+
+// Mirrors the visage on the board, a quarter turn swaps the texture axis that flips
+func (v *Visage) mirror(horizontal bool) {
+	if horizontal == (v.rotation%180 == 0) {
+		v.flipX = !v.flipX
+	} else {
+		v.flipY = !v.flipY
+	}
+}
+
+// Splits plain copies off the texture before its pixels change
+func (g *Game) prepareEdit(index int) {
+	g.loadFullResolution(index)
+
+	v := &g.visages[index]
+	if v.shared == nil {
+		return
+	}
+	if !v.linked {
+		g.materialize(index)
+		return
+	}
+	for i := range g.visages {
+		if w := &g.visages[i]; w.shared == v.shared && !w.linked {
+			g.materialize(i)
+		}
+	}
+}
+
+func (g *Game) materialize(index int) {
+	v := &g.visages[index]
+	if v.shared == nil {
+		return
+	}
+
+	img := ebiten.NewImage(v.image.Bounds().Dx(), v.image.Bounds().Dy())
+	img.DrawImage(v.image, nil)
+	if v.detachImage() { // Was the last one using it
+		v.image.Deallocate()
+	}
+	v.image = img
+}
+
+// Replaces the texture of every linked instance at once, like loading the full resolution
+func (g *Game) replaceLinkedImage(index int, img *ebiten.Image) {
+	v := g.visages[index]
+	if v.shared == nil || !v.linked {
+		g.visages[index].replaceImage(img)
+		return
+	}
+
+	shared := &sharedImage{}
+	for i := range g.visages {
+		if w := &g.visages[i]; w.shared == v.shared && w.linked {
+			w.replaceImage(img)
+			w.source = nil
+			w.shared = shared
+			w.linked = true
+			shared.refs++
+		}
+	}
+	if shared.refs == 1 {
+		g.visages[index].shared = nil
+		g.visages[index].linked = false
+	}
+}
+
+func (g *Game) instanceAction(selectedIndex int) {
+	if len(g.visages) == 0 || !g.selected || g.erasingToggle || g.selectTool != selectNone {
+		return
+	}
+
+	v := g.visages[selectedIndex]
+	if v.image == nil || v.anim != nil || v.loading != nil {
+		return
+	}
+
+	g.visages = append(g.visages, g.duplicate(selectedIndex, true))
+	g.selectedIndex = len(g.visages) - 1
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Action that can be bound to keys, looked up by name from the keymap file
+type namedAction struct {
+	name        string
+	description string
+	run         func(selectedIndex int)
+	enabled     func() bool // Nil when the action always applies
+	chords      []chord
+	repeat      bool // Runs again while the key is held
+}
+
+// Row of the action table with the keys bound by default
+type actionSpec struct {
+	name, description string
+	run               func(int)
+	enabled           func() bool
+	keys              []string
+}
+
+type modifiers int
+
+const (
+	modCtrl modifiers = 1 << iota
+	modShift
+	modAlt
+	modMeta
+)
+
+// Key pressed while exactly the modifiers are held
+type chord struct {
+	key  ebiten.Key
+	mods modifiers
+}
+
+// Overrides of the default bindings, an empty list unbinds the action
+type keymapFile struct {
+	Version  int                 `json:"version"`
+	Bindings map[string][]string `json:"bindings"`
+}
+
+const (
+	keymapName          = "keymap.json"
+	keymapVersion       = 1
+	shortcutRows        = 24
+	shortcutColumnWidth = 300
+	shortcutLineHeight  = 16
+	shortcutPadding     = 16
+)
+
+var modifierNames = []struct {
+	mod   modifiers
+	names []string
+}{
+	{modCtrl, []string{"ctrl", "control"}},
+	{modShift, []string{"shift"}},
+	{modAlt, []string{"alt", "option"}},
+	{modMeta, []string{"meta", "cmd", "super"}},
+}
+
+// Parses chords like "Ctrl+Shift+P", "?" is Shift+Slash
+func parseChord(s string) (chord, error) {
+	if s == "?" {
+		return chord{ebiten.KeySlash, modShift}, nil
+	}
+
+	parts := strings.Split(s, "+")
+	var c chord
+	for _, part := range parts[:len(parts)-1] {
+		found := false
+		for _, m := range modifierNames {
+			for _, name := range m.names {
+				if strings.EqualFold(strings.TrimSpace(part), name) {
+					c.mods |= m.mod
+					found = true
+				}
+			}
+		}
+		if !found {
+			return chord{}, fmt.Errorf("unknown modifier %q in %q", part, s)
+		}
+	}
+	if err := c.key.UnmarshalText([]byte(strings.TrimSpace(parts[len(parts)-1]))); err != nil {
+		return chord{}, fmt.Errorf("unknown key in %q", s)
+	}
+	return c, nil
+}
+
+func (c chord) String() string {
+	if c.key == ebiten.KeySlash && c.mods == modShift {
+		return "?"
+	}
+
+	var parts []string
+	for _, m := range modifierNames {
+		if c.mods&m.mod != 0 {
+			name := m.names[0]
+			parts = append(parts, strings.ToUpper(name[:1])+name[1:])
+		}
+	}
+	return strings.Join(append(parts, c.key.String()), "+")
+}
+
+func currentModifiers() modifiers {
+	var mods modifiers
+	if ebiten.IsKeyPressed(ebiten.KeyControl) {
+		mods |= modCtrl
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyShift) {
+		mods |= modShift
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyAlt) {
+		mods |= modAlt
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyMeta) {
+		mods |= modMeta
+	}
+	return mods
+}
+
+// Every bindable action in cheat sheet order with its default keys and when it applies
+func (g *Game) registerActions() {
+	selected := func() bool {
+		return len(g.visages) > 0 && g.selected
+	}
+	selectedImage := func() bool {
+		return selected() && g.visages[g.selectedIndex].image != nil
+	}
+	arranging := func() bool { // Move, delete and copy are blocked by the pixel tools
+		return selected() && !g.erasingToggle && g.selectTool == selectNone
+	}
+	drawing := func() bool {
+		return !g.erasingToggle
+	}
+	selectTool := func(tool int) func() bool {
+		return func() bool {
+			return g.selectTool == tool || (selectedImage() && !g.erasingToggle)
+		}
+	}
+	animated := func() bool {
+		return g.selectedAnimation() != nil
+	}
+	overlay := func() bool {
+		return desktop
+	}
+	picker := func() bool { // Desktop builds take files dropped on the window
+		return !desktop
+	}
+	movable := func() bool {
+		return arranging() && !g.visages[g.selectedIndex].locked
+	}
+	resizable := func() bool {
+		return g.selectTool == selectWand || animated() || g.selectedShape() != nil || g.selectedNote() != nil
+	}
+
+	actions := []actionSpec{
+		{"move", "Move", g.moveAction, arranging, []string{"W"}},
+		{"flip", "Flip", g.flipAction, func() bool {
+			return selected() && g.visages[g.selectedIndex].note == nil && g.visages[g.selectedIndex].loading == nil
+		}, []string{"F"}},
+		{"rotate", "Rotate", g.rotateAction, selectedImage, []string{"R"}},
+		{"erase", "Eraser", g.eraseAction, func() bool {
+			return g.erasingToggle || (selectedImage() && g.selectTool == selectNone)
+		}, []string{"E"}},
+		{"delete", "Delete", g.deleteAction, func() bool {
+			return arranging() && !g.visages[g.selectedIndex].locked
+		}, []string{"D"}},
+		{"copy", "Duplicate", g.copyAction, func() bool {
+			return arranging() && g.visages[g.selectedIndex].loading == nil
+		}, []string{"C"}},
+		{"instance", "Linked instance", g.instanceAction, func() bool {
+			return arranging() && selectedImage() && g.visages[g.selectedIndex].anim == nil
+		}, []string{"Ctrl+D", "Meta+D"}},
+		{"undo", "Undo", g.undoAction, func() bool {
+			return len(g.undoStack) > 0
+		}, []string{"Ctrl+Z", "Meta+Z"}},
+		{"save", "Save board", g.saveAction, nil, []string{"Ctrl+S", "Meta+S"}},
+		{"export", "Export PNG", g.exportAction, func() bool {
+			return len(g.visages) > 0
+		}, []string{"Ctrl+E", "Meta+E"}},
+		{"info", "Image info", g.infoAction, nil, []string{"Ctrl+I", "Meta+I"}},
+		{"text", "Text box", g.textAction, drawing, []string{"T"}},
+		{"sticky", "Sticky note", g.stickyAction, drawing, []string{"N"}},
+		{"caption", "Caption", g.captionAction, func() bool {
+			return selected() && !g.erasingToggle && g.visages[g.selectedIndex].note == nil
+		}, []string{"A"}},
+		{"edit", "Edit text", g.editAction, func() bool {
+			return g.selectTool == selectPolygon || (!g.erasingToggle && g.selectedNote() != nil)
+		}, []string{"Enter"}},
+		{"larger", "Larger", g.largerAction, resizable, []string{"Equal"}},
+		{"smaller", "Smaller", g.smallerAction, resizable, []string{"Minus"}},
+		{"color", "Next color", g.colorAction, func() bool {
+			return g.selectedShape() != nil || g.selectedNote() != nil
+		}, []string{"O"}},
+		{"rect", "Rectangle", g.rectToolAction, drawing, []string{"B"}},
+		{"ellipse", "Ellipse", g.ellipseToolAction, drawing, []string{"V"}},
+		{"line", "Line", g.lineToolAction, drawing, []string{"L"}},
+		{"arrow", "Arrow", g.arrowToolAction, drawing, []string{"K"}},
+		{"fill", "Toggle fill", g.fillAction, func() bool {
+			s := g.selectedShape()
+			return s != nil && !s.isLine()
+		}, []string{"P"}},
+		{"cancel", "Cancel tool", g.cancelToolAction, nil, []string{"Escape"}},
+		{"cancelImports", "Cancel imports", g.cancelImportsAction, func() bool {
+			return len(g.imports) > 0
+		}, []string{"Shift+Escape"}},
+		{"wand", "Magic wand", g.wandAction, selectTool(selectWand), []string{"M"}},
+		{"lasso", "Lasso", g.lassoAction, selectTool(selectLasso), []string{"Q"}},
+		{"polygon", "Polygon select", g.polygonAction, selectTool(selectPolygon), []string{"G"}},
+		{"eraseSelection", "Erase selection", g.eraseSelectionAction, g.selectionValid, []string{"Delete", "Backspace"}},
+		{"eraseOutside", "Erase outside", g.eraseOutsideAction, g.selectionValid, []string{"U"}},
+		{"chromaKey", "Chroma key", g.chromaKeyAction, func() bool {
+			return selectedImage() && !g.erasingToggle
+		}, []string{"X"}},
+		{"featherSmaller", "Less feather", g.featherSmallerAction, nil, []string{"Comma"}},
+		{"featherLarger", "More feather", g.featherLargerAction, nil, []string{"Period"}},
+		{"brushSmaller", "Smaller brush", g.brushSmallerAction, nil, []string{"BracketLeft"}},
+		{"brushLarger", "Larger brush", g.brushLargerAction, nil, []string{"BracketRight"}},
+		{"playPause", "Play or pause", g.playPauseAction, animated, []string{"Space"}},
+		{"previousFrame", "Previous frame", g.previousFrameAction, animated, []string{"PageUp"}},
+		{"nextFrame", "Next frame", g.nextFrameAction, animated, []string{"PageDown"}},
+		{"onionSkin", "Onion skin", g.onionSkinAction, animated, []string{"I"}},
+		{"clipboardCopy", "Copy", g.clipboardCopyAction, func() bool {
+			return selected() && g.visages[g.selectedIndex].loading == nil
+		}, []string{"Ctrl+C", "Meta+C"}},
+		{"paste", "Paste", g.pasteAction, func() bool {
+			return g.clipboardID != 0 && !g.erasingToggle
+		}, []string{"Ctrl+V", "Meta+V"}},
+		{"import", "Import files", g.importAction, picker, []string{"Ctrl+O", "Meta+O"}},
+		{"selectAll", "Select all", g.selectAllAction, func() bool {
+			return len(g.visages) > 0 && !g.erasingToggle && g.selectTool == selectNone
+		}, []string{"Ctrl+A", "Meta+A"}},
+		{"fitView", "Fit all", g.fitViewAction, func() bool {
+			return !g.visibleBounds().Empty()
+		}, []string{"Home"}},
+		{"frameSelection", "Frame selection", g.frameSelectionAction, selected, []string{"Shift+F"}},
+		{"actualSize", "Reset to 100%", g.actualSizeAction, func() bool {
+			return g.view.zoom != 1
+		}, []string{"Ctrl+Digit0", "Meta+Digit0"}},
+		{"lock", "Lock", g.lockAction, selected, nil},
+		{"hide", "Hide", g.hideAction, arranging, []string{"H"}},
+		{"showHidden", "Show hidden", g.showHiddenAction, func() bool {
+			return g.hiddenCount() > 0
+		}, []string{"Shift+H"}},
+		{"opacity", "Opacity", g.opacityAction, selectedImage, nil},
+		{"reset", "Reset size", g.resetAction, func() bool {
+			return selectedImage() && !g.visages[g.selectedIndex].locked
+		}, nil},
+		{"exportImage", "Export image", g.exportImageAction, func() bool {
+			return selectedImage() && g.visages[g.selectedIndex].loading == nil
+		}, []string{"Ctrl+Shift+E", "Meta+Shift+E"}},
+		{"quality", "Image quality", g.lodQualityAction, nil, []string{"Ctrl+L", "Meta+L"}},
+		{"palette", "Command palette", g.paletteAction, nil, []string{"Ctrl+K", "Meta+K", "Ctrl+Shift+P", "Meta+Shift+P"}},
+		{"minimap", "Minimap", g.minimapAction, nil, []string{"Ctrl+M", "Meta+M"}},
+		{"dock", "Dock toolbar", g.dockAction, nil, []string{"Ctrl+B", "Meta+B"}},
+		{"alwaysOnTop", "Always on top", g.alwaysOnTopAction, overlay, []string{"Ctrl+Shift+T", "Meta+Shift+T"}},
+		{"transparentBackground", "Transparent background", g.transparentBackgroundAction, overlay, []string{"Ctrl+Shift+B", "Meta+Shift+B"}},
+		{"clickThrough", "Click-through", g.clickThroughAction, overlay, []string{"Ctrl+Shift+X", "Meta+Shift+X"}},
+		{"windowOpacityUp", "More window opacity", g.windowOpacityUpAction, func() bool {
+			return desktop && g.windowOpacity < 1
+		}, []string{"Ctrl+Shift+Equal", "Meta+Shift+Equal"}},
+		{"windowOpacityDown", "Less window opacity", g.windowOpacityDownAction, func() bool {
+			return desktop && g.windowOpacity > windowOpacityMin
+		}, []string{"Ctrl+Shift+Minus", "Meta+Shift+Minus"}},
+		{"theme", "Light or dark theme", g.themeAction, nil, []string{"Ctrl+Shift+L", "Meta+Shift+L"}},
+		{"backgroundColor", "Background color", g.backgroundColorAction, nil, []string{"Ctrl+Shift+C", "Meta+Shift+C"}},
+		{"backgroundPattern", "Background pattern", g.backgroundPatternAction, nil, []string{"Ctrl+Shift+G", "Meta+Shift+G"}},
+		{"uiScaleUp", "Larger UI", g.uiScaleUpAction, func() bool {
+			return g.uiScale() < uiScaleMax
+		}, []string{"Ctrl+Equal", "Meta+Equal"}},
+		{"uiScaleDown", "Smaller UI", g.uiScaleDownAction, func() bool {
+			return g.uiScale() > uiScaleMin
+		}, []string{"Ctrl+Minus", "Meta+Minus"}},
+		{"uiScaleAuto", "UI scale of the monitor", g.uiScaleAutoAction, func() bool {
+			return g.settings.UIScale != 0
+		}, []string{"Ctrl+Shift+Digit0", "Meta+Shift+Digit0"}},
+		{"nudgeLeft", "Nudge left (Shift for 10 px)", g.nudgeLeftAction, movable, []string{"ArrowLeft", "Shift+ArrowLeft"}},
+		{"nudgeRight", "Nudge right (Shift for 10 px)", g.nudgeRightAction, movable, []string{"ArrowRight", "Shift+ArrowRight"}},
+		{"nudgeUp", "Nudge up (Shift for 10 px)", g.nudgeUpAction, movable, []string{"ArrowUp", "Shift+ArrowUp"}},
+		{"nudgeDown", "Nudge down (Shift for 10 px)", g.nudgeDownAction, movable, []string{"ArrowDown", "Shift+ArrowDown"}},
+		{"inspector", "Transform inspector", g.inspectorAction, nil, []string{"Ctrl+Shift+I", "Meta+Shift+I"}},
+		{"preferences", "Preferences", g.preferencesAction, nil, []string{"Ctrl+Comma", "Meta+Comma"}},
+		{"shortcuts", "Shortcuts", g.shortcutsAction, nil, []string{"?"}},
+		{"fpsDebug", "FPS overlay", g.fpsDebugAction, nil, []string{"F3"}},
+		{"memoryDebug", "Memory overlay", g.memoryDebugAction, nil, []string{"F4"}},
+	}
+
+	for n := 1; n <= viewpoints; n++ {
+		n := n
+		actions = append(actions, actionSpec{fmt.Sprintf("viewpoint%d", n), fmt.Sprintf("Go to viewpoint %d", n), func(int) {
+			g.goToViewpoint(n)
+		}, func() bool {
+			_, ok := g.viewpoints[n]
+			return ok
+		}, []string{fmt.Sprintf("Digit%d", n)}}, actionSpec{fmt.Sprintf("saveViewpoint%d", n), fmt.Sprintf("Save viewpoint %d", n), func(int) {
+			g.saveViewpoint(n)
+		}, nil, []string{fmt.Sprintf("Ctrl+Digit%d", n), fmt.Sprintf("Meta+Digit%d", n)}})
+	}
+
+	g.actions = nil
+	for _, a := range actions {
+		na := namedAction{
+			name:        a.name,
+			description: a.description,
+			run:         a.run,
+			enabled:     a.enabled,
+		}
+		for _, key := range a.keys {
+			c, err := parseChord(key)
+			if err != nil {
+				panic(err) // The defaults are fixed
+			}
+			na.chords = append(na.chords, c)
+		}
+		g.actions = append(g.actions, na)
+	}
+	for _, name := range []string{"nudgeLeft", "nudgeRight", "nudgeUp", "nudgeDown"} {
+		g.actionByName(name).repeat = true
+	}
+}
+
+func (g *Game) actionByName(name string) *namedAction {
+	for i := range g.actions {
+		if g.actions[i].name == name {
+			return &g.actions[i]
+		}
+	}
+	return nil
+}
+
+// Applies the keymap file over the defaults and reports what could not be bound
+func (g *Game) loadKeymap() {
+	data, err := readConfig(keymapName)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		g.notify(severityWarning, "Failed to read %s: %v", keymapName, err)
+	}
+	if err == nil {
+		var file keymapFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			g.notify(severityWarning, "Failed to decode %s: %v", keymapName, err)
+		} else if file.Version > keymapVersion {
+			g.notify(severityWarning, "%s is from a newer version, using the default keys", keymapName)
+		} else {
+			g.applyKeymap(file)
+		}
+	}
+	g.bindActions()
+}
+
+func (g *Game) applyKeymap(file keymapFile) {
+	for name, keys := range file.Bindings {
+		a := g.actionByName(name)
+		if a == nil {
+			g.notify(severityWarning, "%s: unknown action %q", keymapName, name)
+			continue
+		}
+
+		a.chords = nil
+		for _, key := range keys {
+			c, err := parseChord(key)
+			if err != nil {
+				g.notify(severityWarning, "%s: %v", keymapName, err)
+				continue
+			}
+			a.chords = append(a.chords, c)
+		}
+	}
+}
+
+// Maps every chord to its action, the action listed first keeps a chord bound twice
+func (g *Game) bindActions() {
+	g.bindings = map[chord]*namedAction{}
+	g.boundKeys = map[ebiten.Key]bool{}
+	for i := range g.actions {
+		a := &g.actions[i]
+		chords := a.chords[:0]
+		for _, c := range a.chords {
+			if other, ok := g.bindings[c]; ok {
+				g.notify(severityWarning, "%s is bound to both %s and %s, keeping %s", c, other.name, a.name, other.name)
+				continue
+			}
+			g.bindings[c] = a
+			g.boundKeys[c.key] = true
+			chords = append(chords, c)
+		}
+		a.chords = chords
+	}
+}
+
+// Runs the action bound to a key as it goes down with the modifiers held,
+// the palette and preferences only close on their own key and the context menu keeps them all
+func (g *Game) handleKeybinds() {
+	mods := currentModifiers()
+	for key := range g.boundKeys {
+		pressed := ebiten.IsKeyPressed(key)
+		if pressed && g.editNote == nil && !g.inspectorEditing() { // Keys are typed into the note or field being edited
+			if a, ok := g.bindings[chord{key, mods}]; ok && (!pressedKeys[key] || a.repeat && repeatingKeyPressed(key)) && (g.palette == nil || a.name == "palette") && (g.preferences == nil || a.name == "preferences") && g.menu == nil {
+				g.runAction(a)
+			}
+		}
+		pressedKeys[key] = pressed
+	}
+}
+
+func (a namedAction) keys() string {
+	names := make([]string, len(a.chords))
+	for i, c := range a.chords {
+		names[i] = c.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+func (g *Game) drawShortcuts(screen *ebiten.Image) {
+	columns := (len(g.actions) + shortcutRows - 1) / shortcutRows
+	w := float32(columns*shortcutColumnWidth + 2*shortcutPadding)
+	h := float32(shortcutRows*shortcutLineHeight + 2*shortcutPadding)
+	x := (float32(screen.Bounds().Dx()) - w) / 2
+	y := (float32(screen.Bounds().Dy()) - h) / 2
+	vector.DrawFilledRect(screen, x, y, w, h, g.theme().panel, false)
+
+	for i, a := range g.actions {
+		cx := int(x) + shortcutPadding + i/shortcutRows*shortcutColumnWidth
+		cy := int(y) + shortcutPadding + i%shortcutRows*shortcutLineHeight
+		ebitenutil.DebugPrintAt(screen, a.description, cx, cy)
+		ebitenutil.DebugPrintAt(screen, a.keys(), cx+shortcutColumnWidth/2, cy)
+	}
+}
+
+func (g *Game) shortcutsAction(selectedIndex int) {
+	g.showShortcuts = !g.showShortcuts
+}
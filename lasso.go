@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+const polygonMinPoints = 3
+
+func (g *Game) handleLasso(v *Visage, x, y int, pressed bool) {
+	if pressed {
+		if x < v.x || x > v.x+v.w || y < v.y || y > v.y+v.h {
+			return
+		}
+		g.clearSelection()
+	} else if g.selectPoints == nil { // Drag started outside the visage
+		return
+	}
+
+	px, py := getPixelCoordinates(v, x, y)
+	if n := len(g.selectPoints); n > 0 && g.selectPoints[n-1] == [2]int{px, py} {
+		return
+	}
+	g.selectPoints = append(g.selectPoints, [2]int{px, py})
+}
+
+func (g *Game) handlePolygon(v *Visage, x, y int) {
+	if len(g.selectPoints) >= polygonMinPoints {
+		fx, fy := imageToScreen(*v, g.selectPoints[0][0], g.selectPoints[0][1])
+		if math.Abs(float64(x)-float64(fx)) <= float64(g.settings.HandleArea) && math.Abs(float64(y)-float64(fy)) <= float64(g.settings.HandleArea) {
+			g.finishSelectionPath()
+			return
+		}
+	}
+
+	if len(g.selectPoints) == 0 {
+		if x < v.x || x > v.x+v.w || y < v.y || y > v.y+v.h {
+			return
+		}
+		g.clearSelection()
+	}
+
+	px, py := getPixelCoordinates(v, x, y)
+	g.selectPoints = append(g.selectPoints, [2]int{px, py})
+}
+
+// Closes the lasso or polygon and turns it into the selection
+func (g *Game) finishSelectionPath() {
+	points := g.selectPoints
+	g.selectPoints = nil
+	if len(points) < polygonMinPoints || !g.selected {
+		return
+	}
+
+	v := g.screenVisage(g.visages[g.selectedIndex])
+	w, h := v.image.Bounds().Dx(), v.image.Bounds().Dy()
+	mask := rasterizePolygon(points, w, h)
+
+	// Feather is in screen pixels, the mask is in image pixels
+	radius := int(math.Round(float64(g.feather) * float64(w) / float64(v.w)))
+	featherMask(mask, w, h, radius)
+	g.setSelection(v.image, mask)
+}
+
+// Even-odd scanline fill sampled at pixel centers
+func rasterizePolygon(points [][2]int, w, h int) []float32 {
+	mask := make([]float32, w*h)
+	var xs []float64
+	for y := 0; y < h; y++ {
+		cy := float64(y) + 0.5
+		xs = xs[:0]
+		for i := range points {
+			x0, y0 := float64(points[i][0]), float64(points[i][1])
+			x1, y1 := float64(points[(i+1)%len(points)][0]), float64(points[(i+1)%len(points)][1])
+			if (y0 <= cy) == (y1 <= cy) {
+				continue
+			}
+			xs = append(xs, x0+(cy-y0)*(x1-x0)/(y1-y0))
+		}
+		sort.Float64s(xs)
+
+		for i := 0; i+1 < len(xs); i += 2 {
+			start := int(math.Max(0, math.Ceil(xs[i]-0.5)))
+			end := int(math.Min(float64(w), math.Ceil(xs[i+1]-0.5)))
+			for x := start; x < end; x++ {
+				mask[y*w+x] = 1
+			}
+		}
+	}
+	return mask
+}
+
+// Softens the mask edges with two passes of a separable box blur
+func featherMask(mask []float32, w, h, radius int) {
+	if radius <= 0 {
+		return
+	}
+
+	tmp := make([]float32, len(mask))
+	for pass := 0; pass < 2; pass++ {
+		boxBlur(mask, tmp, w, h, radius, 1, w)
+		boxBlur(tmp, mask, h, w, radius, w, 1)
+	}
+}
+
+// Blurs each line of n samples spaced by step, lines are stride apart
+func boxBlur(src, dst []float32, n, lines, radius, step, stride int) {
+	scale := 1 / float32(2*radius+1)
+	for l := 0; l < lines; l++ {
+		base := l * stride
+		var sum float32
+		for i := -radius; i <= radius; i++ {
+			sum += src[base+clampIndex(i, n)*step]
+		}
+		for i := 0; i < n; i++ {
+			dst[base+i*step] = sum * scale
+			sum += src[base+clampIndex(i+radius+1, n)*step] - src[base+clampIndex(i-radius, n)*step]
+		}
+	}
+}
+
+func clampIndex(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
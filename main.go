@@ -1,40 +1,59 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	"image/color"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
-	"io/fs"
 	"log"
 	"math"
 	"sync"
+	"sync/atomic"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text/v2"
 	"github.com/hajimehoshi/ebiten/v2/vector"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/font/gofont/goregular"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
 type Visage struct {
-	x, y  int
-	w, h  int
-	image *ebiten.Image
-}
-
-type Button struct {
-	w, h    int
-	xOffset int
-	yOffset int
-	image   *ebiten.Image
-	action  func(selectedIndex int)
+	id           int
+	x, y         int
+	w, h         int
+	image        *ebiten.Image
+	note         *Note  // Set for text boxes and sticky notes, image is nil
+	shape        *Shape // Set for vector annotations, image is nil
+	anim         *Animation
+	svg          *vectorImage
+	meta         *Metadata
+	source       *imageSource // Set while image is a downscaled proxy
+	loading      *importJob   // Set for the placeholder of a file being imported
+	mips         *mipChain
+	evicted      *evictedTexture // Set while the texture is deallocated offscreen
+	seen         int             // Tick the visage was last on screen
+	shared       *sharedImage    // Set while other visages draw the same texture
+	linked       bool            // Edits reach the other linked instances
+	caption      *Note
+	locked       bool // Kept from being moved, resized or deleted
+	hidden       bool
+	transparency int  // Percent, 0 is opaque
+	rotation     int  // Clockwise quarter turns in degrees, applied when drawn
+	flipX, flipY bool // Mirrors the texture before the turn, the pixels stay as imported
 }
 
 type Game struct {
 	visages        []Visage
-	buttons        []Button
-	err            error
+	toolbar        []toolButton
+	dock           int
+	toasts         []toast
 	m              sync.Mutex
 	cursor         ebiten.CursorShapeType
 	prevMouseX     int
@@ -53,14 +72,76 @@ type Game struct {
 	erasingToggle  bool
 	sliderDragging bool
 	sliderValue    int
+	sliderIndex    int
+	brushHardness  int
+	brushOpacity   int
+	brushSpacing   int
+	brushImage     *ebiten.Image
+	brushPixels    []byte
+	brushCarry     float64
+	fontSource     *text.GoTextFaceSource
+	editNote       *Note
+	inputChars     []rune
+	ticks          int
+	nextID         int
+	shapeTool      int
+	creatingShape  bool // The shape being sized was just started
+	selectTool     int
+	selectPoints   [][2]int // Lasso or polygon points in image pixels
+	selectMask     []float32
+	selectImage    *ebiten.Image
+	selectOverlay  *ebiten.Image
+	wandColor      [4]float64
+	wandHasColor   bool
+	wandTolerance  int
+	feather        int
+	undoStack      []undoEntry
+	showInfo       bool
+	imports        []*importJob
+	importSlots    chan struct{}
+	dropped        chan droppedFile
+	proxyLimit     int
+	lodQuality     int
+	mipBudget      int
+	index          spatialIndex
+	textureBudget  int
+	dirty          atomic.Bool // Set through markDirty, the screen is redrawn when set
+	cursorX        int
+	cursorY        int
+	screenWidth    int
+	screenHeight   int
+	uiWidth        int // Size of the chrome layer, scaled up by uiScale onto the screen
+	uiHeight       int
+	actions        []namedAction
+	bindings       map[chord]*namedAction
+	boundKeys      map[ebiten.Key]bool
+	showShortcuts  bool
+	palette        *commandPalette
+	preferences    *preferencesPanel
+	inspector      *transformInspector
+	recentActions  []string
+	menu           *contextMenu
+	panMoved       bool // The right button moved past the threshold since it went down
+	clipboardID    int
+	group          map[int]bool // Ids of the visages selected together
+	view           view
+	viewAnim       *viewAnimation
+	viewpoints     map[int]view
+	showMinimap    bool
+	minimapDrag    *[4]float64 // Board extent of the minimap held while dragging on it
+	transparent    bool
+	windowOpacity  float64
+	canvas         *ebiten.Image // Drawn to while the window is translucent
+	chrome         *ebiten.Image
+	settings       settings
+	tile           *ebiten.Image // Background pattern
+	tileColor      color.RGBA
+	tilePattern    string
 }
 
-var keyActions = map[ebiten.Key]func(int){}
 var pressedKeys = map[ebiten.Key]bool{}
 
 const (
-	handleArea        = 8
-	handleDisplaySize = 4
 	handleNone        = 0
 	handleTopLeft     = 1
 	handleTopRight    = 2
@@ -75,88 +156,32 @@ const (
 	erasingOOBOffset  = 80
 )
 
-var (
-	colorEraser = color.RGBA{255, 32, 78, 200}
-)
-
-const cursorDebug = false
-const actionDebug = false
-const fpsDebug = true
-
-func (g *Game) handleErrors() error {
-	if err := func() error {
-		g.m.Lock()
-		defer g.m.Unlock()
-		return g.err
-	}(); err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func (g *Game) handleDroppedFiles() {
 	if files := ebiten.DroppedFiles(); files != nil {
-		go func() {
-			if err := fs.WalkDir(files, ".", func(path string, d fs.DirEntry, err error) error {
-				if err != nil {
-					return err
-				}
-
-				fi, err := d.Info()
-				if err != nil {
-					return err
-				}
-				log.Printf("Name: %s, Size: %d, IsDir: %t, ModTime: %v", fi.Name(), fi.Size(), fi.IsDir(), fi.ModTime())
-
-				f, err := files.Open(path)
-				if err != nil {
-					return err
-				}
-				defer func() {
-					_ = f.Close()
-				}()
-
-				img, _, err := image.Decode(f)
-				if err != nil {
-					log.Printf("Failed to decode the image file: %v", err)
-					return nil
-				}
-
-				eimg := ebiten.NewImageFromImage(img)
-
-				g.m.Lock()
-				newVisage := Visage{
-					x:     40,
-					y:     40,
-					w:     eimg.Bounds().Dx(),
-					h:     eimg.Bounds().Dy(),
-					image: eimg,
-				}
-				g.visages = append(g.visages, newVisage)
-				g.m.Unlock()
-
-				return nil
-			}); err != nil {
-				g.m.Lock()
-				if g.err == nil {
-					g.err = err
-				}
-				g.m.Unlock()
-			}
-		}()
+		go g.importFiles(files)
 	}
 }
 
+// Takes the point on the chrome, the handles and sliders are hit there
 func (g *Game) handleCursor(x, y int) {
+	handleArea := g.settings.HandleArea
 	cursor := ebiten.CursorShapeDefault
 
+	if g.shapeTool != shapeNone {
+		cursor = ebiten.CursorShapeCrosshair
+	}
+
 	if g.selected {
-		v := g.visages[g.selectedIndex]
+		v := g.chromeVisage(g.visages[g.selectedIndex])
 
-		if g.erasingToggle {
+		if g.erasingToggle || g.selectTool != selectNone {
 			// if out of bounds
-			if x < v.x-erasingOOBOffset || x > v.x+v.w+erasingOOBOffset || y < v.y-erasingOOBOffset || y > v.y+v.h+erasingOOBOffset {
+			outOfBounds := x < v.x-erasingOOBOffset || x > v.x+v.w+erasingOOBOffset || y < v.y-erasingOOBOffset || y > v.y+v.h+erasingOOBOffset
+			if g.erasingToggle {
+				outOfBounds = g.outsideEraserArea(v, x, y)
+			}
+
+			if outOfBounds {
 				cursor = ebiten.CursorShapePointer
 			} else {
 				cursor = ebiten.CursorShapeCrosshair
@@ -164,17 +189,6 @@ func (g *Game) handleCursor(x, y int) {
 
 		}
 
-		for i, button := range g.buttons { // Button Hover Cursor
-			yOffset := v.y + (buttonSize * i)
-			if x >= v.x+button.xOffset && x <= v.x+button.xOffset+buttonSize && y >= yOffset && y <= yOffset+buttonSize {
-				if g.erasingToggle && !containsIndex([]int{1, 2, 3}, i) {
-					cursor = ebiten.CursorShapeNotAllowed
-				} else {
-					cursor = ebiten.CursorShapePointer
-				}
-			}
-		}
-
 		if g.dragging {
 			cursor = ebiten.CursorShapeMove
 		}
@@ -190,6 +204,18 @@ func (g *Game) handleCursor(x, y int) {
 		}
 	}
 
+	if g.showMinimap && image.Pt(x, y).In(g.minimapBounds()) {
+		cursor = ebiten.CursorShapePointer
+	}
+
+	if i := g.buttonAt(x, y); i >= 0 { // Button Hover Cursor
+		if g.buttonEnabled(g.toolbar[i]) {
+			cursor = ebiten.CursorShapePointer
+		} else {
+			cursor = ebiten.CursorShapeNotAllowed
+		}
+	}
+
 	if g.panning {
 		cursor = ebiten.CursorShapeMove
 	}
@@ -200,35 +226,37 @@ func (g *Game) handleCursor(x, y int) {
 	}
 }
 
-func (g *Game) handleKeybinds() {
-	for key, action := range keyActions {
-		if ebiten.IsKeyPressed(key) {
-			if !pressedKeys[key] {
-				action(g.selectedIndex)
-			}
-			pressedKeys[key] = true
-		} else {
-			pressedKeys[key] = false
-		}
+func (g *Game) handleMouseActions(x, y int) {
+	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) || ebiten.IsMouseButtonPressed(ebiten.MouseButtonRight) {
+		g.finishViewAnimation() // The view is handed back before anything moves
 	}
-}
 
-func (g *Game) handleMouseActions(x, y int) {
+	ux, uy := g.uiPoint(x, y)
 	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
-		g.handleLeftMouseButton(x, y)
+		if g.dragging || g.resizing || g.clicking || !g.handleMinimap(ux, uy) && !g.handleInspector(ux, uy) {
+			g.handleLeftMouseButton(x, y)
+		}
 	} else if ebiten.IsMouseButtonPressed(ebiten.MouseButtonRight) {
 		g.handlePanning(x, y)
 	} else {
 		if g.resizing {
 			g.handleResizeMouseRelease()
 		}
+		if g.panning && !g.panMoved && inpututil.IsMouseButtonJustReleased(ebiten.MouseButtonRight) {
+			g.openContextMenu(x, y)
+		}
 
 		g.handleMouseRelease()
 	}
 }
 
+// The handles keep their size on the chrome whatever the zoom
 func (g *Game) checkResizeHandles(x, y int) {
-	v := g.visages[g.selectedIndex]
+	v := g.chromeVisage(g.visages[g.selectedIndex])
+	if v.locked {
+		return
+	}
+	handleArea := g.settings.HandleArea
 	if x >= v.x-handleArea && x <= v.x+handleArea && y >= v.y-handleArea && y <= v.y+handleArea {
 		g.resizing = true
 		g.resizeHandle = handleTopLeft
@@ -244,94 +272,12 @@ func (g *Game) checkResizeHandles(x, y int) {
 	}
 }
 
-func getPixelCoordinates(v *Visage, x, y int) (int, int) { // Needed for erasing resized images
-	return int(float64(x-v.x) * (float64(v.image.Bounds().Dx()) / float64(v.w))), int(float64(y-v.y) * (float64(v.image.Bounds().Dy()) / float64(v.h)))
-}
-
-func abs(x int) int {
-	if x < 0 {
-		return -x
-	}
-	return x
-}
-
-// Bresenham's line algorithm with thickness
-func drawLine(img *ebiten.Image, x0, y0, x1, y1, thickness int, col color.Color) {
-	dx := abs(x1 - x0)
-	dy := abs(y1 - y0)
-	sx := -1
-	if x0 < x1 {
-		sx = 1
-	}
-	sy := -1
-	if y0 < y1 {
-		sy = 1
-	}
-	err := dx - dy
-
-	// TODO: Fix this
-	drawCircle(img, x0, y0, thickness/2, color.RGBA{0, 0, 0, 0})
-
-	for {
-		for t := -thickness / 2; t <= thickness/2; t++ {
-			if dx > dy {
-				setPixel(img, x0, y0+t, col)
-			} else {
-				setPixel(img, x0+t, y0, col)
-			}
-		}
-
-		if x0 == x1 && y0 == y1 {
-			break
-		}
-		e2 := 2 * err
-		if e2 > -dy {
-			err -= dy
-			x0 += sx
-		}
-		if e2 < dx {
-			err += dx
-			y0 += sy
-		}
-	}
-
-	// TODO: Fix this
-	drawCircle(img, x1, y1, thickness/2, color.RGBA{0, 0, 0, 0})
-}
-
-// Bresenham's circle algorithm
-func drawCircle(img *ebiten.Image, x0, y0, radius int, col color.Color) {
-	x := radius
-	y := 0
-	err := 1 - x
-
-	for x >= y {
-		drawHorizontalLine(img, x0-y, x0+y, y0+x, col)
-		drawHorizontalLine(img, x0-y, x0+y, y0-x, col)
-		drawHorizontalLine(img, x0-x, x0+x, y0+y, col)
-		drawHorizontalLine(img, x0-x, x0+x, y0-y, col)
-
-		y++
-		if err < 0 {
-			err += 2*y + 1
-		} else {
-			x--
-			err += 2*(y-x) + 1
-		}
-	}
-}
-
-func drawHorizontalLine(img *ebiten.Image, x1, x2, y int, col color.Color) {
-	for x := x1; x <= x2; x++ {
-		setPixel(img, x, y, col)
-	}
-}
-
-// Function to set a pixel in the image, checking bounds
-func setPixel(img *ebiten.Image, x, y int, col color.Color) {
-	if x >= 0 && x < img.Bounds().Max.X && y >= 0 && y < img.Bounds().Max.Y {
-		img.Set(x, y, col)
-	}
+func getPixelCoordinates(v *Visage, x, y int) (int, int) { // Needed for erasing resized, flipped and turned images
+	var geoM ebiten.GeoM
+	v.orient(&geoM, v.image.Bounds().Dx(), v.image.Bounds().Dy())
+	geoM.Invert()
+	px, py := geoM.Apply(float64(x), float64(y))
+	return int(math.Floor(px)), int(math.Floor(py))
 }
 
 func (g *Game) updateSliderValue(value int) {
@@ -348,18 +294,24 @@ func (g *Game) handleErasing(x, y int) {
 		return
 	}
 
-	v := &g.visages[g.selectedIndex]
+	v := g.screenVisage(g.visages[g.selectedIndex]) // Erases as finely as the screen shows the image
+	c := g.chromeVisage(g.visages[g.selectedIndex]) // The sliders are on the chrome
+	ux, uy := g.uiPoint(x, y)
 
 	// Slider dragging
-	sliderMouseOffset := 14
-	if x >= v.x+(v.w/2)-(sliderWidth/2)-sliderMouseOffset && x <= v.x+(v.w/2)-(sliderWidth/2)+sliderWidth+sliderMouseOffset && y >= v.y+v.h+sliderYOffset-sliderMouseOffset && y <= v.y+v.h+sliderYOffset+sliderHeight+sliderMouseOffset {
-		g.sliderDragging = true
-		g.updateSliderValue(x - (v.x + (v.w / 2) - (sliderWidth / 2)))
+	if !g.sliderDragging && g.brushPixels == nil {
+		if i := g.sliderAt(c, ux, uy); i >= 0 {
+			g.sliderDragging = true
+			g.sliderIndex = i
+		}
+	}
+	if g.sliderDragging {
+		g.brushSliders()[g.sliderIndex].setFromX(c, ux)
 		return
 	}
 
 	// Check if outofbounds + an offset for deselecting the eraser
-	if x < v.x-erasingOOBOffset || x > v.x+v.w+erasingOOBOffset || y < v.y-erasingOOBOffset || y > v.y+v.h+erasingOOBOffset {
+	if g.outsideEraserArea(c, ux, uy) {
 		g.erasingToggle = false
 		return
 	}
@@ -377,87 +329,87 @@ func (g *Game) handleErasing(x, y int) {
 		return
 	}
 
-	px, py := getPixelCoordinates(v, x, y)
+	px, py := getPixelCoordinates(&v, x, y)
 	if g.prevMouseX == 0 && g.prevMouseY == 0 {
 		g.prevMouseX = px
 		g.prevMouseY = py
 	}
 
-	// draw transparent line
-	drawLine(v.image, g.prevMouseX, g.prevMouseY, px, py, g.sliderValue, color.RGBA{0, 0, 0, 0})
+	if g.brushPixels == nil {
+		g.startBrushStroke(g.selectedIndex)
+	}
+	g.brushStroke(&v, g.prevMouseX, g.prevMouseY, px, py)
 	g.prevMouseX = px
 	g.prevMouseY = py
 }
 
-func containsIndex(arr []int, val int) bool {
-	for _, v := range arr {
-		if v == val {
-			return true
-		}
-	}
-	return false
-}
-
-func (g *Game) checkButtonClicks(x, y int) {
-	v := g.visages[g.selectedIndex]
-
-	for i, button := range g.buttons {
-		yOffset := v.y + (buttonSize * i)
-		if g.erasingToggle && !containsIndex([]int{1, 2, 3}, i) {
-			continue
-		}
-
-		if x >= v.x+button.xOffset && x <= v.x+button.xOffset+buttonSize && y >= yOffset && y <= yOffset+buttonSize {
-			button.action(g.selectedIndex)
-			g.clicking = true
-		}
-	}
-}
-
+// Buttons and handles take the point on the chrome, the pixel tools the
+// screen point, visages are moved on the board
 func (g *Game) handleLeftMouseButton(x, y int) {
+	bx, by := g.toBoard(x, y)
+	ux, uy := g.uiPoint(x, y)
 	if !g.dragging && !g.resizing {
+		if !g.clicking {
+			g.checkButtonClicks(ux, uy)
+		}
+		if g.selected && !g.clicking {
+			g.checkResizeHandles(ux, uy)
+		}
 		if g.selected {
-			g.checkResizeHandles(x, y)
-			if !g.clicking {
-				g.checkButtonClicks(x, y)
-			}
 			if g.erasingToggle {
 				g.handleErasing(x, y)
 			}
+			if g.selectTool != selectNone {
+				g.handleSelectTool(x, y)
+			}
 		}
 
-		if !g.resizing && !g.clicking && !g.erasingToggle {
-			g.checkVisageDrag(x, y)
+		if g.shapeTool != shapeNone && !g.resizing && !g.clicking && !g.erasingToggle && g.selectTool == selectNone {
+			g.startShape(bx, by)
+		} else if !g.resizing && !g.clicking && !g.erasingToggle && g.selectTool == selectNone {
+			g.checkVisageDrag(bx, by)
 		}
 	} else if g.dragging {
-		g.dragSelectedVisage(x, y)
+		g.dragSelectedVisage(bx, by)
 	} else if g.resizing {
-		g.resizeSelectedVisage(x, y)
+		g.resizeSelectedVisage(bx, by)
 	}
 }
 
 func (g *Game) checkVisageDrag(x, y int) {
-	for i := len(g.visages) - 1; i >= 0; i-- {
+	hit := false
+	hits := g.visagesAt(x, y)
+	for j := len(hits) - 1; j >= 0; j-- {
+		i := hits[j]
 		v := g.visages[i]
-		if x >= v.x && x <= v.x+v.w && y >= v.y && y <= v.y+v.h {
-			g.dragging = true
+		if g.hitVisage(v, x, y) {
+			hit = true
+			g.dragging = !v.locked
 			g.selected = true
 			g.selectedIndex = i
 			g.dragOffsetX = x - v.x
 			g.dragOffsetY = y - v.y
+			if !g.group[v.id] {
+				g.group = nil
+			}
 			break
 		}
 	}
 
-	if !g.dragging { // Clicked outside of visage
+	if !hit { // Clicked outside of visage
 		g.selected = false
+		g.group = nil
 	}
 }
 
 func (g *Game) dragSelectedVisage(x, y int) {
 	v := &g.visages[g.selectedIndex]
-	v.x = x - g.dragOffsetX
-	v.y = y - g.dragOffsetY
+	dx, dy := x-g.dragOffsetX-v.x, y-g.dragOffsetY-v.y
+	v.x += dx
+	v.y += dy
+	if g.group != nil {
+		g.dragGroup(dx, dy)
+	}
 }
 
 func (g *Game) resizeSelectedVisage(x, y int) {
@@ -483,30 +435,41 @@ func (g *Game) resizeSelectedVisage(x, y int) {
 }
 
 func (g *Game) handleResizeMouseRelease() {
+	if g.visages[g.selectedIndex].shape != nil {
+		g.finishShape()
+		g.resizing = false
+		g.resizeHandle = handleNone
+		return
+	}
+
 	v := &g.visages[g.selectedIndex]
 
+	if v.image == nil { // Notes have no pixels to flip
+		if v.w < 0 {
+			v.x += v.w
+			v.w = -v.w
+		}
+		if v.h < 0 {
+			v.y += v.h
+			v.h = -v.h
+		}
+	}
+
 	if v.w < 0 {
 		v.x += v.w
 		v.w = -v.w
-		// flip image horizontally
-		flippedImage := ebiten.NewImage(v.image.Bounds().Dx(), v.image.Bounds().Dy())
-		op := &ebiten.DrawImageOptions{}
-		op.GeoM.Scale(-1, 1)
-		op.GeoM.Translate(float64(v.image.Bounds().Dx()), 0)
-		flippedImage.DrawImage(v.image, op)
-		v.image = flippedImage
+		v.mirror(true)
 	}
 
 	if v.h < 0 {
 		v.y += v.h
 		v.h = -v.h
-		// flip image vertically
-		flippedImage := ebiten.NewImage(v.image.Bounds().Dx(), v.image.Bounds().Dy())
-		op := &ebiten.DrawImageOptions{}
-		op.GeoM.Scale(1, -1)
-		op.GeoM.Translate(0, float64(v.image.Bounds().Dy()))
-		flippedImage.DrawImage(v.image, op)
-		v.image = flippedImage
+		v.mirror(false)
+	}
+
+	if v.image != nil {
+		g.refreshVectorImage(g.selectedIndex)
+		g.refreshProxy(g.selectedIndex)
 	}
 
 	g.resizing = false
@@ -514,10 +477,19 @@ func (g *Game) handleResizeMouseRelease() {
 }
 
 func (g *Game) handleMouseRelease() {
+	if g.dragging && g.selected {
+		g.attachShapeEnds(g.selectedIndex)
+	}
+	if g.selectTool == selectLasso && g.selectPoints != nil {
+		g.finishSelectionPath()
+	}
+
 	g.dragging = false
 	g.clicking = false
 	g.panning = false
+	g.minimapDrag = nil
 	g.sliderDragging = false
+	g.endBrushStroke()
 	g.prevMouseX = 0
 	g.prevMouseY = 0
 }
@@ -527,50 +499,110 @@ func (g *Game) handlePanning(x, y int) {
 		g.panStartX = x
 		g.panStartY = y
 		g.panning = true
+		g.panMoved = false
 	} else {
-		dx := x - g.panStartX
-		dy := y - g.panStartY
-		for i := range g.visages {
-			g.visages[i].x += dx
-			g.visages[i].y += dy
+		if !g.panMoved && math.Abs(float64(x-g.panStartX))+math.Abs(float64(y-g.panStartY)) <= menuDragThreshold {
+			return // Still a click opening the context menu
 		}
+		g.panMoved = true
+		g.panBy(float64(x-g.panStartX), float64(y-g.panStartY))
 		g.panStartX = x
 		g.panStartY = y
 	}
 }
 
 func (g *Game) drawVisages(screen *ebiten.Image) {
-	for _, visage := range g.visages {
-		op := &ebiten.DrawImageOptions{}
-		op.Filter = ebiten.FilterLinear
-		op.GeoM.Scale(float64(visage.w)/float64(visage.image.Bounds().Dx()), float64(visage.h)/float64(visage.image.Bounds().Dy()))
-		op.GeoM.Translate(float64(visage.x), float64(visage.y))
-		screen.DrawImage(visage.image, op)
+	for _, i := range g.visagesIn(g.view.boardRect(screen.Bounds())) { // Offscreen visages are skipped
+		g.drawVisage(screen, g.screenVisage(g.visages[i]))
+	}
+
+	if g.selected && g.selectTool != selectNone {
+		g.drawSelection(screen, g.screenVisage(g.visages[g.selectedIndex]))
+	}
+}
+
+// Borders, handles and sliders of the selection, drawn at the UI scale
+func (g *Game) drawVisageChrome(screen *ebiten.Image) {
+	for _, i := range g.visagesIn(g.view.boardRect(image.Rect(0, 0, g.screenWidth, g.screenHeight))) {
+		if v := g.visages[i]; g.group[v.id] && !v.hidden {
+			g.drawVisageBorder(screen, g.chromeVisage(v))
+		}
 	}
 
 	if g.selected {
-		v := g.visages[g.selectedIndex]
+		v := g.chromeVisage(g.visages[g.selectedIndex])
 		g.drawVisageBorder(screen, v)
 		g.drawResizeHandles(screen, v)
-		g.drawButtons(screen, v)
+		if v.anim != nil {
+			g.drawAnimationInfo(screen, v)
+		} else if v.linked && v.shared != nil {
+			ebitenutil.DebugPrintAt(screen, "Linked instance", v.x, v.y-animInfoYOffset)
+		} else if v.locked {
+			ebitenutil.DebugPrintAt(screen, "Locked", v.x, v.y-animInfoYOffset)
+		}
+		if g.showInfo && v.meta != nil {
+			g.drawInfo(screen, v)
+		}
 		if g.erasingToggle {
 			g.drawEraser(screen, v)
 		}
 	}
 }
 
+// Draws the visage placed on screen by screenVisage
+func (g *Game) drawVisage(screen *ebiten.Image, visage Visage) {
+	if visage.hidden {
+		return
+	}
+
+	if visage.loading != nil {
+		g.drawPlaceholder(screen, visage)
+		return
+	}
+
+	if visage.note != nil {
+		g.drawNote(screen, visage)
+		return
+	}
+
+	if visage.shape != nil {
+		g.drawShape(screen, visage)
+		return
+	}
+
+	img := visage.image
+	if visage.mips != nil && visage.mips.base == visage.image {
+		img = visage.mips.level(g.lodLevel(visage))
+	}
+
+	op := &ebiten.DrawImageOptions{}
+	op.Filter = ebiten.FilterLinear
+	visage.orient(&op.GeoM, img.Bounds().Dx(), img.Bounds().Dy())
+	op.ColorScale.ScaleAlpha(1 - float32(visage.transparency)/100)
+	screen.DrawImage(img, op)
+
+	if visage.anim != nil && visage.anim.onion {
+		g.drawOnionSkin(screen, visage, op)
+	}
+
+	if visage.caption != nil {
+		g.drawCaption(screen, visage)
+	}
+}
+
 func (g *Game) drawVisageBorder(screen *ebiten.Image, v Visage) {
-	var colorWhite = color.RGBA{0, 0, 0, 255}
+	colorBorder := g.theme().border
 	var borderThickness float32 = 2
-	vector.DrawFilledRect(screen, float32(v.x), float32(v.y), float32(v.w), borderThickness, colorWhite, false)
-	vector.DrawFilledRect(screen, float32(v.x), float32(v.y+v.h), float32(v.w)+borderThickness, borderThickness, colorWhite, false)
-	vector.DrawFilledRect(screen, float32(v.x), float32(v.y), borderThickness, float32(v.h), colorWhite, false)
-	vector.DrawFilledRect(screen, float32(v.x+v.w), float32(v.y), borderThickness, float32(v.h)+borderThickness, colorWhite, false)
+	vector.DrawFilledRect(screen, float32(v.x), float32(v.y), float32(v.w), borderThickness, colorBorder, false)
+	vector.DrawFilledRect(screen, float32(v.x), float32(v.y+v.h), float32(v.w)+borderThickness, borderThickness, colorBorder, false)
+	vector.DrawFilledRect(screen, float32(v.x), float32(v.y), borderThickness, float32(v.h), colorBorder, false)
+	vector.DrawFilledRect(screen, float32(v.x+v.w), float32(v.y), borderThickness, float32(v.h)+borderThickness, colorBorder, false)
 }
 
 func (g *Game) drawResizeHandles(screen *ebiten.Image, v Visage) {
-	colorWhite := color.RGBA{255, 255, 255, 255}
-	colorBlack := color.RGBA{0, 0, 0, 255}
+	colorWhite := g.theme().handleOutline
+	colorBlack := g.theme().handle
+	handleDisplaySize := g.settings.HandleSize
 	vector.DrawFilledCircle(screen, float32(v.x), float32(v.y), float32(handleDisplaySize)+1, colorWhite, false)
 	vector.DrawFilledCircle(screen, float32(v.x+v.w), float32(v.y), float32(handleDisplaySize)+1, colorWhite, false)
 	vector.DrawFilledCircle(screen, float32(v.x), float32(v.y+v.h), float32(handleDisplaySize)+1, colorWhite, false)
@@ -581,52 +613,34 @@ func (g *Game) drawResizeHandles(screen *ebiten.Image, v Visage) {
 	vector.DrawFilledCircle(screen, float32(v.x+v.w), float32(v.y+v.h), float32(handleDisplaySize), colorBlack, false)
 }
 
-func (g *Game) drawButtons(screen *ebiten.Image, v Visage) {
-	for i, button := range g.buttons {
-		xOffset := v.x + button.xOffset
-		yOffset := v.y + button.yOffset + (buttonSize * i)
-		padding := 2
-		colorBlack := color.RGBA{0, 0, 0, 255}
-
-		vector.DrawFilledRect(screen, float32(xOffset), float32(yOffset), float32(buttonSize), float32(buttonSize), colorBlack, false)
-
-		if g.erasingToggle && containsIndex([]int{1, 2, 3}, i) {
-			vector.DrawFilledRect(screen, float32(xOffset), float32(yOffset), float32(buttonSize), float32(buttonSize), colorEraser, true)
-		}
-
-		op := &ebiten.DrawImageOptions{}
-		op.Filter = ebiten.FilterLinear
-		op.GeoM.Scale(float64(button.w)/float64(button.image.Bounds().Dx()), float64(button.h)/float64(button.image.Bounds().Dy()))
-		op.GeoM.Translate(float64(xOffset+padding), float64(yOffset+padding))
-		screen.DrawImage(button.image, op)
-	}
-}
-
 func (g *Game) drawEraser(screen *ebiten.Image, v Visage) {
-	x, y := ebiten.CursorPosition()
+	x, y := g.uiPoint(ebiten.CursorPosition())
 	// if out of bounds don't draw
-	if x < v.x-erasingOOBOffset || x > v.x+v.w+erasingOOBOffset || y < v.y-erasingOOBOffset || y > v.y+v.h+erasingOOBOffset {
+	if g.outsideEraserArea(v, x, y) {
 		return
 	}
 
-	// Eraser cursor
-	vector.DrawFilledCircle(screen, float32(x), float32(y), float32(g.sliderValue)/2, colorEraser, false)
+	// Eraser cursor, the size is in screen pixels
+	vector.DrawFilledCircle(screen, float32(x), float32(y), float32(g.sliderValue)/2/float32(g.uiScale()), g.theme().eraser, false)
 
-	colorWhite := color.RGBA{255, 255, 255, 255}
-	colorBlack := color.RGBA{0, 0, 0, 255}
-	// Eraser slider
-	vector.DrawFilledRect(screen, float32(v.x+(v.w/2)-(sliderWidth/2)), float32(v.y+v.h+sliderYOffset), sliderWidth, sliderHeight, colorBlack, false)
-	vector.DrawFilledCircle(screen, float32(v.x+(v.w/2)-(sliderWidth/2)+g.sliderValue), float32(v.y+v.h+sliderYOffset+4), 12, colorBlack, false)
-	vector.DrawFilledCircle(screen, float32(v.x+(v.w/2)-(sliderWidth/2)+g.sliderValue), float32(v.y+v.h+sliderYOffset+4), 10, colorWhite, false)
+	// Eraser sliders
+	g.drawBrushSliders(screen, v)
 }
 
 func (g *Game) drawDebugInfo(screen *ebiten.Image) {
-	if fpsDebug {
+	if g.settings.FPSDebug || g.settings.MemoryDebug {
+		g.markDirty() // The counters change every frame
+	}
+	if g.settings.FPSDebug {
 		vector.DrawFilledRect(screen, 0, 0, 140, 20, color.RGBA{100, 100, 100, 200}, false)
 		ebitenutil.DebugPrint(screen, "TPS: "+fmt.Sprintf("%.2f", ebiten.ActualTPS())+" FPS: "+fmt.Sprintf("%.2f", ebiten.ActualFPS()))
 	}
 
-	if cursorDebug {
+	if g.settings.MemoryDebug {
+		g.drawMemoryDebug(screen)
+	}
+
+	if g.settings.CursorDebug {
 		vector.DrawFilledRect(screen, 0, 0, 120, 20, color.RGBA{100, 100, 100, 200}, false)
 		switch ebiten.CursorShape() {
 		case ebiten.CursorShapeDefault:
@@ -642,7 +656,7 @@ func (g *Game) drawDebugInfo(screen *ebiten.Image) {
 		}
 	}
 
-	if actionDebug {
+	if g.settings.ActionDebug {
 		vector.DrawFilledRect(screen, 0, 0, 120, 20, color.RGBA{100, 100, 100, 200}, false)
 		switch {
 		case g.dragging:
@@ -655,6 +669,8 @@ func (g *Game) drawDebugInfo(screen *ebiten.Image) {
 			ebitenutil.DebugPrint(screen, "Action: Clicking")
 		case g.erasingToggle:
 			ebitenutil.DebugPrint(screen, "Action: Erasing")
+		case g.selectTool != selectNone:
+			ebitenutil.DebugPrint(screen, "Action: Selecting")
 		default:
 			ebitenutil.DebugPrint(screen, "Action: None")
 		}
@@ -662,7 +678,7 @@ func (g *Game) drawDebugInfo(screen *ebiten.Image) {
 }
 
 func (g *Game) moveAction(selectedIndex int) {
-	if len(g.visages) == 0 || !g.selected || g.erasingToggle {
+	if len(g.visages) == 0 || !g.selected || g.erasingToggle || g.selectTool != selectNone {
 		return
 	}
 
@@ -678,41 +694,67 @@ func (g *Game) moveAction(selectedIndex int) {
 }
 
 func (g *Game) flipAction(selectedIndex int) {
-	if len(g.visages) == 0 || !g.selected {
+	if len(g.visages) == 0 || !g.selected || g.visages[selectedIndex].note != nil || g.visages[selectedIndex].loading != nil {
 		return
 	}
 
 	visage := &g.visages[selectedIndex]
-	flippedImage := ebiten.NewImage(visage.image.Bounds().Dx(), visage.image.Bounds().Dy())
+	if visage.shape != nil {
+		visage.shape.flipX = !visage.shape.flipX
+		return
+	}
+
+	visage.mirror(true)
+}
+
+func flipHorizontal(img *ebiten.Image) *ebiten.Image {
+	flippedImage := ebiten.NewImage(img.Bounds().Dx(), img.Bounds().Dy())
 	op := &ebiten.DrawImageOptions{}
 	op.GeoM.Scale(-1, 1)
-	op.GeoM.Translate(float64(visage.image.Bounds().Dx()), 0)
-	flippedImage.DrawImage(visage.image, op)
-	visage.image = flippedImage
+	op.GeoM.Translate(float64(img.Bounds().Dx()), 0)
+	flippedImage.DrawImage(img, op)
+	return flippedImage
+}
+
+func flipVertical(img *ebiten.Image) *ebiten.Image {
+	flippedImage := ebiten.NewImage(img.Bounds().Dx(), img.Bounds().Dy())
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Scale(1, -1)
+	op.GeoM.Translate(0, float64(img.Bounds().Dy()))
+	flippedImage.DrawImage(img, op)
+	return flippedImage
 }
 
 func (g *Game) rotateAction(selectedIndex int) {
-	if len(g.visages) == 0 || !g.selected {
+	if len(g.visages) == 0 || !g.selected || g.visages[selectedIndex].image == nil {
 		return
 	}
 
 	visage := &g.visages[selectedIndex]
-	rotatedImage := ebiten.NewImage(visage.image.Bounds().Dy(), visage.image.Bounds().Dx())
+	visage.rotation = (visage.rotation + 90) % 360
+	visage.w, visage.h = visage.h, visage.w
+}
+
+func rotateClockwise(img *ebiten.Image) *ebiten.Image {
+	rotatedImage := ebiten.NewImage(img.Bounds().Dy(), img.Bounds().Dx())
 	op := &ebiten.DrawImageOptions{}
-	op.GeoM.Translate(-float64(visage.image.Bounds().Dx())/2, -float64(visage.image.Bounds().Dy())/2)
+	op.GeoM.Translate(-float64(img.Bounds().Dx())/2, -float64(img.Bounds().Dy())/2)
 	op.GeoM.Rotate(math.Pi / 2)
-	op.GeoM.Translate(float64(visage.image.Bounds().Dy())/2, float64(visage.image.Bounds().Dx())/2)
-	rotatedImage.DrawImage(visage.image, op)
-	visage.image = rotatedImage
-	visage.w = rotatedImage.Bounds().Dx()
-	visage.h = rotatedImage.Bounds().Dy()
+	op.GeoM.Translate(float64(img.Bounds().Dy())/2, float64(img.Bounds().Dx())/2)
+	rotatedImage.DrawImage(img, op)
+	return rotatedImage
 }
 
 func (g *Game) deleteAction(selectedIndex int) {
-	if len(g.visages) == 0 || !g.selected || g.erasingToggle {
+	if len(g.visages) == 0 || !g.selected || g.erasingToggle || g.selectTool != selectNone || g.visages[selectedIndex].locked {
 		return
 	}
 
+	if job := g.visages[selectedIndex].loading; job != nil {
+		job.cancelled.Store(true)
+	}
+
+	releaseVisage(g.visages[selectedIndex])
 	if len(g.visages) <= 1 {
 		g.visages = nil
 		g.selected = false
@@ -724,106 +766,188 @@ func (g *Game) deleteAction(selectedIndex int) {
 }
 
 func (g *Game) copyAction(selectedIndex int) {
-	if len(g.visages) == 0 || !g.selected || g.erasingToggle {
+	if len(g.visages) == 0 || !g.selected || g.erasingToggle || g.selectTool != selectNone || g.visages[selectedIndex].loading != nil {
 		return
 	}
 
-	visage := g.visages[selectedIndex]
-	newImage := ebiten.NewImage(visage.image.Bounds().Dx(), visage.image.Bounds().Dy())
-	newImage.DrawImage(visage.image, nil)
-	newVisage := Visage{
-		x:     visage.x + 30,
-		y:     visage.y + 30,
-		w:     visage.w,
-		h:     visage.h,
-		image: newImage,
-	}
-	g.visages = append(g.visages, newVisage)
+	g.visages = append(g.visages, g.cloneVisage(selectedIndex))
 	g.selectedIndex = len(g.visages) - 1
 }
 
+// Copy of the visage offset from it, plain images share the pixels until one of them is edited
+func (g *Game) cloneVisage(index int) Visage {
+	visage := g.visages[index]
+	if visage.image != nil && visage.anim == nil {
+		v := g.duplicate(index, false)
+		v.transparency = visage.transparency
+		return v
+	}
+
+	newVisage := Visage{
+		id:      g.newID(),
+		x:       visage.x + 30,
+		y:       visage.y + 30,
+		w:       visage.w,
+		h:       visage.h,
+		note:    visage.note.clone(),
+		shape:   visage.shape.clone(),
+		anim:    visage.anim.clone(),
+		svg:     visage.svg.clone(),
+		meta:    visage.meta,
+		source:  visage.source.clone(),
+		caption: visage.caption.clone(),
+
+		transparency: visage.transparency,
+		rotation:     visage.rotation,
+		flipX:        visage.flipX,
+		flipY:        visage.flipY,
+	}
+	if newVisage.shape != nil { // The copy would snap back onto the attached visages
+		newVisage.shape.startID = 0
+		newVisage.shape.endID = 0
+	}
+	if newVisage.anim != nil {
+		newVisage.image = newVisage.anim.frames[newVisage.anim.frame]
+	} else if visage.image != nil {
+		newVisage.image = ebiten.NewImage(visage.image.Bounds().Dx(), visage.image.Bounds().Dy())
+		newVisage.image.DrawImage(visage.image, nil)
+	}
+	return newVisage
+}
+
 func (g *Game) eraseAction(selectedIndex int) {
+	if !g.erasingToggle && (len(g.visages) == 0 || g.visages[selectedIndex].image == nil || g.selectTool != selectNone) {
+		return
+	}
+
 	g.erasingToggle = !g.erasingToggle
 	log.Println("Erasing: ", g.erasingToggle)
 }
 
 func loadAssets(g *Game) {
-	icons := []struct {
-		path   string
-		action func(selectedIndex int)
-	}{
-		{"assets/move.png", g.moveAction},
-		{"assets/flip.png", g.flipAction},
-		{"assets/rotate.png", g.rotateAction},
-		{"assets/erase.png", g.eraseAction},
-		{"assets/delete.png", g.deleteAction},
-		{"assets/copy.png", g.copyAction},
-	}
-
-	for _, icon := range icons {
-		img, _, err := ebitenutil.NewImageFromFile(icon.path)
-		if err != nil {
-			log.Fatal(err)
-		}
+	g.loadToolbar()
 
-		button := Button{
-			w:       28,
-			h:       28,
-			xOffset: -38,
-			yOffset: 10,
-			image:   img,
-			action:  icon.action,
-		}
-		g.buttons = append(g.buttons, button)
+	fontSource, err := text.NewGoTextFaceSource(bytes.NewReader(goregular.TTF))
+	if err != nil {
+		log.Fatal(err)
 	}
+	g.fontSource = fontSource
 }
 
 func (g *Game) Update() error {
-	g.handleErrors()
+	g.ticks++
+	g.updateToasts()
+	g.updateImports()
+	g.updateView()
+	g.updateFullResolution()
+	g.updateMips()
 	g.handleDroppedFiles()
+	g.handleTextInput()
+	g.handleInspectorKeys()
 	g.handleKeybinds()
 
 	x, y := ebiten.CursorPosition()
-	g.handleMouseActions(x, y)
-	g.handleCursor(x, y)
+	ux, uy := g.uiPoint(x, y) // The chrome is hit in its own pixels
+	g.syncIndex()
+	if g.palette != nil {
+		g.handlePalette(ux, uy)
+	} else if g.preferences != nil {
+		g.handlePreferences(ux, uy)
+	} else if g.menu != nil {
+		g.handleContextMenu(ux, uy)
+	} else {
+		g.handleMouseActions(x, y)
+	}
+	g.handleCursor(ux, uy)
+	g.updateShapeAttachments()
+	g.updateAnimations()
+
+	g.updateTextures()
+	g.updateIdle(x, y)
 
 	return nil
 }
 
 func (g *Game) Draw(screen *ebiten.Image) {
-	screen.Fill(color.RGBA{240, 235, 230, 255}) // Background color
-	screen.Fill(color.RGBA{120, 120, 120, 200})
-
 	g.m.Lock()
 	defer g.m.Unlock()
 
-	g.drawDebugInfo(screen)
-	g.drawVisages(screen)
+	if !g.dirty.Swap(false) { // Nothing changed since the last frame, the screen still holds it
+		return
+	}
+
+	target := g.drawTarget(screen)
+	g.drawBackground(target)
+	g.syncIndex()
+	g.drawVisages(target)
+
+	chrome := g.chromeTarget()
+	g.drawDebugInfo(chrome)
+	g.drawVisageChrome(chrome)
+	g.drawToolbar(chrome)
+	if g.showMinimap {
+		g.drawMinimap(chrome)
+	}
+	if g.inspector != nil {
+		g.drawInspector(chrome)
+	}
+	if g.showShortcuts {
+		g.drawShortcuts(chrome)
+	}
+	if g.menu != nil {
+		g.drawContextMenu(chrome)
+	}
+	if g.palette != nil {
+		g.drawPalette(chrome)
+	}
+	if g.preferences != nil {
+		g.drawPreferences(chrome)
+	}
+	g.drawToasts(chrome)
+	g.presentChrome(target, chrome)
+	g.presentTarget(screen, target)
 }
 
+// The screen has a pixel for every device pixel, the chrome is drawn smaller and scaled up to the UI scale
 func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
-	return outsideWidth, outsideHeight
+	scale := ebiten.Monitor().DeviceScaleFactor()
+	w := int(math.Ceil(float64(outsideWidth) * scale))
+	h := int(math.Ceil(float64(outsideHeight) * scale))
+	uw := int(math.Ceil(float64(w) / g.uiScale()))
+	uh := int(math.Ceil(float64(h) / g.uiScale()))
+	if w != g.screenWidth || h != g.screenHeight || uw != g.uiWidth || uh != g.uiHeight {
+		g.m.Lock()
+		g.screenWidth, g.screenHeight = w, h
+		g.uiWidth, g.uiHeight = uw, uh
+		g.m.Unlock()
+		g.markDirty()
+	}
+	return w, h
 }
 
 func main() {
 	g := &Game{
-		sliderValue: 30,
+		brushHardness: brushHardnessMax,
+		brushOpacity:  brushOpacityMax,
+		brushSpacing:  10,
+		wandTolerance: toleranceDefault,
+		feather:       featherDefault,
+		importSlots:   make(chan struct{}, importWorkers),
+		dropped:       make(chan droppedFile, droppedQueue),
+		view:          view{zoom: 1},
+		windowOpacity: 1,
 	}
 
 	loadAssets(g)
 
-	keyActions = map[ebiten.Key]func(int){
-		ebiten.KeyW: g.buttons[0].action,
-		ebiten.KeyF: g.buttons[1].action,
-		ebiten.KeyR: g.buttons[2].action,
-		ebiten.KeyE: g.buttons[3].action,
-		ebiten.KeyD: g.buttons[4].action,
-		ebiten.KeyC: g.buttons[5].action,
-	}
+	g.registerActions()
+	g.loadKeymap()
+	g.loadSettings()
 
+	ebiten.SetScreenClearedEveryFrame(false)
 	ebiten.SetWindowResizingMode(ebiten.WindowResizingModeEnabled)
 	ebiten.SetWindowTitle("visage")
-	if err := ebiten.RunGame(g); err != nil {
+	if err := runGame(g); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Metadata of the file a visage was imported from
+type Metadata struct {
+	name          string
+	width, height int // Before auto-orientation
+	size          int64
+	format        string
+	modTime       time.Time
+	exif          [][2]string // Label and value pairs in display order
+}
+
+const (
+	infoPadding    = 8
+	infoLineHeight = 16
+	infoXOffset    = 16
+)
+
+// Transforms that turn an image stored with the EXIF orientation upright
+var orientationTransforms = map[int][]int{
+	2: {transformFlipHorizontal},
+	3: {transformRotate, transformRotate},
+	4: {transformFlipVertical},
+	5: {transformRotate, transformFlipHorizontal},
+	6: {transformRotate},
+	7: {transformRotate, transformFlipVertical},
+	8: {transformRotate, transformRotate, transformRotate},
+}
+
+// Reads the orientation and camera fields of JPEG and TIFF files
+func readEXIF(data []byte) (int, [][2]string) {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 1, nil
+	}
+
+	orientation := 1
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if o, err := tag.Int(0); err == nil {
+			orientation = o
+		}
+	}
+
+	var fields [][2]string
+	add := func(label, value string) {
+		if value = strings.TrimSpace(value); value != "" {
+			fields = append(fields, [2]string{label, value})
+		}
+	}
+	str := func(name exif.FieldName) string {
+		if tag, err := x.Get(name); err == nil {
+			s, _ := tag.StringVal()
+			return s
+		}
+		return ""
+	}
+
+	add("Camera", strings.TrimSpace(str(exif.Make)+" "+str(exif.Model)))
+	add("Lens", str(exif.LensModel))
+	if t, err := x.DateTime(); err == nil {
+		add("Taken", t.Format(time.DateTime))
+	}
+	if tag, err := x.Get(exif.ExposureTime); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil && num > 0 {
+			if num < den {
+				add("Exposure", fmt.Sprintf("1/%d s", den/num))
+			} else {
+				add("Exposure", fmt.Sprintf("%.3g s", float64(num)/float64(den)))
+			}
+		}
+	}
+	if tag, err := x.Get(exif.FNumber); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil && den > 0 {
+			add("Aperture", fmt.Sprintf("f/%.2g", float64(num)/float64(den)))
+		}
+	}
+	if tag, err := x.Get(exif.ISOSpeedRatings); err == nil {
+		if iso, err := tag.Int(0); err == nil {
+			add("ISO", fmt.Sprint(iso))
+		}
+	}
+	if tag, err := x.Get(exif.FocalLength); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil && den > 0 {
+			add("Focal length", fmt.Sprintf("%.3g mm", float64(num)/float64(den)))
+		}
+	}
+	return orientation, fields
+}
+
+func autoOrient(img *ebiten.Image, orientation int) *ebiten.Image {
+	return transformAll(img, orientationTransforms[orientation])
+}
+
+func (m *Metadata) lines() []string {
+	lines := []string{
+		m.name,
+		fmt.Sprintf("Format: %s", strings.ToUpper(m.format)),
+		fmt.Sprintf("Dimensions: %dx%d", m.width, m.height),
+		fmt.Sprintf("File size: %s", formatSize(m.size)),
+		fmt.Sprintf("Modified: %s", m.modTime.Format(time.DateTime)),
+	}
+	for _, f := range m.exif {
+		lines = append(lines, fmt.Sprintf("%s: %s", f[0], f[1]))
+	}
+	return lines
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+// Popover to the right of the selected visage
+func (g *Game) drawInfo(screen *ebiten.Image, v Visage) {
+	lines := v.meta.lines()
+	width := 0
+	for _, line := range lines {
+		if w := 6 * len(line); w > width { // The debug font is 6 pixels wide
+			width = w
+		}
+	}
+
+	x := float32(v.x + v.w + infoXOffset)
+	y := float32(v.y)
+	vector.DrawFilledRect(screen, x, y, float32(width+2*infoPadding), float32(len(lines)*infoLineHeight+2*infoPadding), g.theme().panel, false)
+	for i, line := range lines {
+		ebitenutil.DebugPrintAt(screen, line, int(x)+infoPadding, int(y)+infoPadding+i*infoLineHeight)
+	}
+}
+
+func (g *Game) infoAction(selectedIndex int) {
+	g.showInfo = !g.showInfo
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+const (
+	minimapWidth   = 200
+	minimapHeight  = 150
+	minimapMargin  = 12
+	minimapPadding = 6
+)
+
+var (
+	colorMinimapVisage   = color.RGBA{170, 170, 170, 255}
+	colorMinimapSelected = color.RGBA{0, 90, 200, 255}
+	colorMinimapViewport = color.RGBA{255, 255, 255, 255}
+)
+
+// Bottom left corner, the toasts stack up in the bottom right
+func (g *Game) minimapBounds() image.Rectangle {
+	return image.Rect(minimapMargin, g.uiHeight-minimapMargin-minimapHeight, minimapMargin+minimapWidth, g.uiHeight-minimapMargin)
+}
+
+// Board area shown on the minimap, held still while dragging so the map does not move under the cursor
+func (g *Game) minimapExtent() (x0, y0, x1, y1 float64) {
+	if g.minimapDrag != nil {
+		return g.minimapDrag[0], g.minimapDrag[1], g.minimapDrag[2], g.minimapDrag[3]
+	}
+
+	r := g.view.boardRect(image.Rect(0, 0, g.screenWidth, g.screenHeight)).Union(g.visibleBounds())
+	return float64(r.Min.X), float64(r.Min.Y), float64(r.Max.X), float64(r.Max.Y)
+}
+
+// Scale and offset from board coordinates to the minimap, centred in it
+func (g *Game) minimapTransform() (scale, ox, oy float64) {
+	x0, y0, x1, y1 := g.minimapExtent()
+	m := g.minimapBounds().Inset(minimapPadding)
+	scale = math.Min(float64(m.Dx())/math.Max(1, x1-x0), float64(m.Dy())/math.Max(1, y1-y0))
+	ox = float64(m.Min.X) + (float64(m.Dx())-(x1-x0)*scale)/2 - x0*scale
+	oy = float64(m.Min.Y) + (float64(m.Dy())-(y1-y0)*scale)/2 - y0*scale
+	return scale, ox, oy
+}
+
+// Board rectangle on the minimap
+func (g *Game) minimapRect(r image.Rectangle) (x, y, w, h float32) {
+	scale, ox, oy := g.minimapTransform()
+	return float32(float64(r.Min.X)*scale + ox), float32(float64(r.Min.Y)*scale + oy), float32(math.Max(1, float64(r.Dx())*scale)), float32(math.Max(1, float64(r.Dy())*scale))
+}
+
+// Centres the view on the point of the minimap under the cursor, true while the minimap has the mouse
+func (g *Game) handleMinimap(x, y int) bool {
+	if !g.showMinimap {
+		return false
+	}
+	if g.minimapDrag == nil {
+		if !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) || !image.Pt(x, y).In(g.minimapBounds()) {
+			return false
+		}
+		x0, y0, x1, y1 := g.minimapExtent()
+		g.minimapDrag = &[4]float64{x0, y0, x1, y1}
+	}
+
+	scale, ox, oy := g.minimapTransform()
+	sx, sy := g.view.screenPoint((float64(x)-ox)/scale, (float64(y)-oy)/scale)
+	g.panBy(float64(g.screenWidth)/2-sx, float64(g.screenHeight)/2-sy)
+	return true
+}
+
+func (g *Game) drawMinimap(screen *ebiten.Image) {
+	m := g.minimapBounds()
+	vector.DrawFilledRect(screen, float32(m.Min.X), float32(m.Min.Y), float32(m.Dx()), float32(m.Dy()), g.theme().panel, false)
+
+	for i, v := range g.visages {
+		if v.hidden {
+			continue
+		}
+		c := colorMinimapVisage
+		if g.selected && i == g.selectedIndex {
+			c = colorMinimapSelected
+		}
+		x, y, w, h := g.minimapRect(g.visageBounds(v))
+		vector.DrawFilledRect(screen, x, y, w, h, c, false)
+	}
+
+	x, y, w, h := g.minimapRect(g.view.boardRect(image.Rect(0, 0, g.screenWidth, g.screenHeight)))
+	vector.StrokeRect(screen, x, y, w, h, 1, colorMinimapViewport, false)
+}
+
+func (g *Game) minimapAction(selectedIndex int) {
+	g.showMinimap = !g.showMinimap
+}
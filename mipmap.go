@@ -0,0 +1,153 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Halved copies of an image drawn in place of it when it is shown much smaller
+type mipChain struct {
+	base   *ebiten.Image   // Image the levels were made from
+	levels []*ebiten.Image // The first level is half the size of base
+	used   int             // Tick the chain was last needed at
+}
+
+const (
+	lodOff   = iota
+	lodFast  // Rounds to the nearest level, smaller textures
+	lodSharp // Never shrinks more than needed
+)
+
+const mipBudgetDefault = 256 << 20 // Bytes of mip levels kept on the GPU
+
+var lodNames = []string{"Off", "Fast", "Sharp"}
+
+// Deepest level available up to the wanted one, the base image is level 0
+func (c *mipChain) level(n int) *ebiten.Image {
+	if n > len(c.levels) {
+		n = len(c.levels)
+	}
+	if n == 0 {
+		return c.base
+	}
+	return c.levels[n-1]
+}
+
+func (c *mipChain) bytes() int {
+	size := 0
+	for _, level := range c.levels {
+		size += 4 * level.Bounds().Dx() * level.Bounds().Dy()
+	}
+	return size
+}
+
+func (c *mipChain) release() {
+	for _, level := range c.levels {
+		level.Deallocate()
+	}
+	c.levels = nil
+}
+
+// Level of detail for the on-screen scale of the visage
+func (g *Game) lodLevel(v Visage) int {
+	if g.lodQuality == lodOff || v.image == nil || v.anim != nil {
+		return 0
+	}
+
+	w, h := v.unturned()
+	scale := math.Min(math.Abs(float64(w))/float64(v.image.Bounds().Dx()), math.Abs(float64(h))/float64(v.image.Bounds().Dy()))
+	if scale <= 0 || scale >= 0.5 {
+		return 0
+	}
+
+	d := math.Log2(1 / scale)
+	if g.lodQuality == lodFast {
+		d += 0.5
+	}
+	return int(d)
+}
+
+// Builds the levels the visages need and drops chains of images that changed
+func (g *Game) updateMips() {
+	for i := range g.visages {
+		v := &g.visages[i]
+		if v.mips != nil && v.mips.base != v.image {
+			v.mips.release()
+			v.mips = nil
+		}
+
+		// Pixels being edited would leave the levels stale
+		if g.selected && g.selectedIndex == i && (g.erasingToggle || g.selectTool != selectNone) {
+			g.invalidateMips(v)
+			continue
+		}
+
+		level := g.lodLevel(g.screenVisage(*v)) // Levels follow the size on screen
+		if level == 0 || v.evicted != nil {
+			continue
+		}
+		if v.mips == nil {
+			v.mips = &mipChain{base: v.image}
+		}
+		v.mips.used = g.ticks
+
+		for len(v.mips.levels) < level {
+			prev := v.mips.level(len(v.mips.levels))
+			w, h := prev.Bounds().Dx()/2, prev.Bounds().Dy()/2
+			if w < 1 || h < 1 || !g.reserveMips(v.mips, 4*w*h) {
+				break
+			}
+
+			next := ebiten.NewImage(w, h)
+			op := &ebiten.DrawImageOptions{}
+			op.Filter = ebiten.FilterLinear // Averages each 2x2 block
+			op.GeoM.Scale(float64(w)/float64(prev.Bounds().Dx()), float64(h)/float64(prev.Bounds().Dy()))
+			next.DrawImage(prev, op)
+			v.mips.levels = append(v.mips.levels, next)
+			g.markDirty()
+		}
+	}
+}
+
+// Evicts the least recently used chains until size more bytes fit in the budget,
+// chains needed this tick are kept so visages don't take turns rebuilding them
+func (g *Game) reserveMips(keep *mipChain, size int) bool {
+	var chains []*mipChain
+	usage := 0
+	for _, v := range g.visages {
+		if v.mips != nil {
+			usage += v.mips.bytes()
+			if v.mips != keep && v.mips.used != g.ticks {
+				chains = append(chains, v.mips)
+			}
+		}
+	}
+	sort.Slice(chains, func(i, j int) bool {
+		return chains[i].used < chains[j].used
+	})
+
+	for _, c := range chains {
+		if usage+size <= g.mipBudget {
+			break
+		}
+		usage -= c.bytes()
+		c.release()
+	}
+	return usage+size <= g.mipBudget
+}
+
+func (g *Game) invalidateMips(v *Visage) {
+	if v.mips != nil {
+		v.mips.release()
+		v.mips = nil
+	}
+}
+
+func (g *Game) lodQualityAction(selectedIndex int) {
+	g.settings.LODQuality = (g.settings.LODQuality + 1) % len(lodNames)
+	g.lodQuality = g.settings.LODQuality
+	g.saveSettings()
+	g.notify(severityInfo, "Image quality: %s", lodNames[g.lodQuality])
+}
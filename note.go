@@ -0,0 +1,334 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Note is the text of a free floating text box, a sticky note or a caption
+type Note struct {
+	text   string
+	size   float64
+	color  color.RGBA
+	sticky bool
+}
+
+const (
+	noteWidth       = 200
+	noteHeight      = 120
+	notePadding     = 8
+	noteSizeMin     = 8
+	noteSizeMax     = 96
+	noteSizeStep    = 2
+	noteDefaultSize = 18
+	captionSize     = 14
+	captionGap      = 6
+	lineSpacing     = 1.25
+)
+
+var (
+	colorSticky       = color.RGBA{255, 233, 128, 255}
+	colorStickyShadow = color.RGBA{0, 0, 0, 60}
+	colorPlaceholder  = color.RGBA{0, 0, 0, 90}
+	noteColors        = []color.RGBA{
+		{0, 0, 0, 255},
+		{255, 255, 255, 255},
+		{220, 40, 60, 255},
+		{30, 100, 220, 255},
+		{30, 150, 70, 255},
+	}
+)
+
+func (n *Note) clone() *Note {
+	if n == nil {
+		return nil
+	}
+	c := *n
+	return &c
+}
+
+func (g *Game) noteFace(size float64) *text.GoTextFace {
+	return &text.GoTextFace{
+		Source: g.fontSource,
+		Size:   size,
+	}
+}
+
+// Greedy word wrap, words longer than the width are left to overflow
+func wrapText(str string, face text.Face, width float64) string {
+	var lines []string
+	for _, paragraph := range strings.Split(str, "\n") {
+		line := ""
+		for _, word := range strings.Split(paragraph, " ") {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if line != "" && text.Advance(candidate, face) > width {
+				lines = append(lines, line)
+				line = word
+			} else {
+				line = candidate
+			}
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (g *Game) noteText(n *Note) string {
+	str := n.text
+	if g.editNote == n && (g.ticks/30)%2 == 0 { // Blinking caret
+		str += "|"
+	}
+	return str
+}
+
+// The visage is on screen, the text is scaled by the view here
+func (g *Game) drawNote(screen *ebiten.Image, v Visage) {
+	n := v.note
+	zoom := g.view.zoom
+	if n.sticky {
+		shadow := float32(3 * zoom)
+		vector.DrawFilledRect(screen, float32(v.x)+shadow, float32(v.y)+shadow, float32(v.w), float32(v.h), colorStickyShadow, false)
+		vector.DrawFilledRect(screen, float32(v.x), float32(v.y), float32(v.w), float32(v.h), colorSticky, false)
+	}
+
+	face := g.noteFace(n.size * zoom)
+	padding := notePadding * zoom
+	str := g.noteText(n)
+	col := n.color
+	if n.text == "" && g.editNote != n {
+		str = "Text"
+		col = colorPlaceholder
+	}
+
+	op := &text.DrawOptions{}
+	op.GeoM.Translate(float64(v.x)+padding, float64(v.y)+padding)
+	op.ColorScale.ScaleWithColor(col)
+	op.LineSpacing = face.Size * lineSpacing
+	text.Draw(screen, wrapText(str, face, float64(v.w)-2*padding), face, op)
+}
+
+// Width the caption wraps at in board pixels
+func (g *Game) captionWidth(v Visage) float64 {
+	return math.Max(float64(v.w), noteWidth)
+}
+
+func (g *Game) captionHeight(v Visage) int {
+	if v.caption == nil {
+		return 0
+	}
+	face := g.noteFace(v.caption.size)
+	_, h := text.Measure(wrapText(g.noteText(v.caption), face, g.captionWidth(v)), face, v.caption.size*lineSpacing)
+	return captionGap + int(math.Ceil(h))
+}
+
+func (g *Game) drawCaption(screen *ebiten.Image, v Visage) {
+	n := v.caption
+	zoom := g.view.zoom
+	face := g.noteFace(n.size * zoom)
+
+	op := &text.DrawOptions{}
+	op.GeoM.Translate(float64(v.x)+float64(v.w)/2, float64(v.y+v.h)+captionGap*zoom)
+	op.ColorScale.ScaleWithColor(n.color)
+	op.LineSpacing = face.Size * lineSpacing
+	op.PrimaryAlign = text.AlignCenter
+	text.Draw(screen, wrapText(g.noteText(n), face, math.Max(float64(v.w), noteWidth*zoom)), face, op)
+}
+
+func (g *Game) handleTextInput() {
+	if g.editNote == nil {
+		return
+	}
+
+	if !g.selected || (g.visages[g.selectedIndex].note != g.editNote && g.visages[g.selectedIndex].caption != g.editNote) {
+		g.stopEditing()
+		return
+	}
+	g.markDirty() // The caret blinks
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.stopEditing()
+		return
+	}
+
+	n := g.editNote
+	g.inputChars = ebiten.AppendInputChars(g.inputChars[:0])
+	n.text += string(g.inputChars)
+
+	if repeatingKeyPressed(ebiten.KeyEnter) || repeatingKeyPressed(ebiten.KeyNumpadEnter) {
+		n.text += "\n"
+	}
+
+	if repeatingKeyPressed(ebiten.KeyBackspace) && len(n.text) > 0 {
+		r := []rune(n.text)
+		n.text = string(r[:len(r)-1])
+	}
+}
+
+// Reports a press on the first tick and then repeatedly while the key is held
+func repeatingKeyPressed(key ebiten.Key) bool {
+	const (
+		delay    = 30
+		interval = 3
+	)
+	d := inpututil.KeyPressDuration(key)
+	if d == 1 {
+		return true
+	}
+	if d >= delay && (d-delay)%interval == 0 {
+		return true
+	}
+	return false
+}
+
+func (g *Game) stopEditing() {
+	for i := range g.visages {
+		if g.visages[i].caption == g.editNote && g.editNote.text == "" {
+			g.visages[i].caption = nil
+		}
+	}
+	g.editNote = nil
+}
+
+func (g *Game) addNote(sticky bool) {
+	if g.erasingToggle {
+		return
+	}
+
+	x, y := g.toBoard(ebiten.CursorPosition())
+	n := &Note{
+		size:   noteDefaultSize,
+		color:  noteColors[0],
+		sticky: sticky,
+	}
+	g.visages = append(g.visages, Visage{
+		id:   g.newID(),
+		x:    x,
+		y:    y,
+		w:    noteWidth,
+		h:    noteHeight,
+		note: n,
+	})
+	g.selected = true
+	g.selectedIndex = len(g.visages) - 1
+	g.editNote = n
+}
+
+// Returns the note of the selected visage, its caption for images
+func (g *Game) selectedNote() *Note {
+	if len(g.visages) == 0 || !g.selected {
+		return nil
+	}
+	v := g.visages[g.selectedIndex]
+	if v.note != nil {
+		return v.note
+	}
+	return v.caption
+}
+
+func (g *Game) textAction(selectedIndex int) {
+	g.addNote(false)
+}
+
+func (g *Game) stickyAction(selectedIndex int) {
+	g.addNote(true)
+}
+
+func (g *Game) captionAction(selectedIndex int) {
+	if len(g.visages) == 0 || !g.selected || g.erasingToggle {
+		return
+	}
+
+	v := &g.visages[selectedIndex]
+	if v.note != nil {
+		return
+	}
+	if v.caption == nil {
+		v.caption = &Note{
+			size:  captionSize,
+			color: noteColors[0],
+		}
+	}
+	g.editNote = v.caption
+}
+
+func (g *Game) editAction(selectedIndex int) {
+	if g.selectTool == selectPolygon {
+		g.finishSelectionPath()
+		return
+	}
+
+	if g.erasingToggle {
+		return
+	}
+
+	if n := g.selectedNote(); n != nil {
+		g.editNote = n
+	}
+}
+
+// Grows the wand tolerance, the animation speed, the font of the selected note or the stroke of the selected shape
+func (g *Game) largerAction(selectedIndex int) {
+	s, n := g.selectedShape(), g.selectedNote()
+	switch {
+	case g.selectTool == selectWand:
+		g.updateTolerance(g.wandTolerance + toleranceStep)
+	case g.selectedAnimation() != nil:
+		g.changeAnimationSpeed(1)
+	case s != nil:
+		if s.strokeWidth < strokeWidthMax {
+			s.strokeWidth++
+		}
+	case n != nil:
+		if n.size < noteSizeMax {
+			n.size += noteSizeStep
+		}
+	}
+}
+
+func (g *Game) smallerAction(selectedIndex int) {
+	s, n := g.selectedShape(), g.selectedNote()
+	switch {
+	case g.selectTool == selectWand:
+		g.updateTolerance(g.wandTolerance - toleranceStep)
+	case g.selectedAnimation() != nil:
+		g.changeAnimationSpeed(-1)
+	case s != nil:
+		if s.strokeWidth > strokeWidthMin {
+			s.strokeWidth--
+		}
+	case n != nil:
+		if n.size > noteSizeMin {
+			n.size -= noteSizeStep
+		}
+	}
+}
+
+func nextColor(c color.RGBA) color.RGBA {
+	for i, nc := range noteColors {
+		if nc == c {
+			return noteColors[(i+1)%len(noteColors)]
+		}
+	}
+	return noteColors[0]
+}
+
+func (g *Game) colorAction(selectedIndex int) {
+	if s := g.selectedShape(); s != nil {
+		s.stroke = nextColor(s.stroke)
+		if s.fill.A > 0 {
+			s.fill = color.RGBA{}
+			g.fillAction(selectedIndex)
+		}
+	} else if n := g.selectedNote(); n != nil {
+		n.color = nextColor(n.color)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Steps of the window opacity while it floats as a reference overlay
+const (
+	windowOpacityMin  = 0.2
+	windowOpacityStep = 0.1
+)
+
+// Everything is drawn offscreen first while the window is translucent
+func (g *Game) drawTarget(screen *ebiten.Image) *ebiten.Image {
+	if g.windowOpacity >= 1 {
+		return screen
+	}
+	w, h := screen.Bounds().Dx(), screen.Bounds().Dy()
+	if g.canvas == nil || g.canvas.Bounds().Dx() != w || g.canvas.Bounds().Dy() != h {
+		if g.canvas != nil {
+			g.canvas.Deallocate()
+		}
+		g.canvas = ebiten.NewImage(w, h)
+	}
+	return g.canvas
+}
+
+// Cleared for the chrome every frame, it has a pixel per UI pixel
+func (g *Game) chromeTarget() *ebiten.Image {
+	if g.chrome == nil || g.chrome.Bounds().Dx() != g.uiWidth || g.chrome.Bounds().Dy() != g.uiHeight {
+		if g.chrome != nil {
+			g.chrome.Deallocate()
+		}
+		g.chrome = ebiten.NewImage(g.uiWidth, g.uiHeight)
+	}
+	g.chrome.Clear()
+	return g.chrome
+}
+
+func (g *Game) presentChrome(target, chrome *ebiten.Image) {
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Scale(g.uiScale(), g.uiScale())
+	op.Filter = ebiten.FilterLinear
+	target.DrawImage(chrome, op)
+}
+
+func (g *Game) presentTarget(screen, target *ebiten.Image) {
+	if target == screen {
+		return
+	}
+	screen.Clear()
+	op := &ebiten.DrawImageOptions{}
+	op.ColorScale.ScaleAlpha(float32(g.windowOpacity))
+	screen.DrawImage(target, op)
+}
+
+func (g *Game) alwaysOnTopAction(selectedIndex int) {
+	if !desktop {
+		return
+	}
+	ebiten.SetWindowFloating(!ebiten.IsWindowFloating())
+}
+
+func (g *Game) transparentBackgroundAction(selectedIndex int) {
+	if !desktop {
+		return
+	}
+	g.transparent = !g.transparent
+}
+
+func (g *Game) clickThroughAction(selectedIndex int) {
+	if !desktop {
+		return
+	}
+	on := !ebiten.IsWindowMousePassthrough()
+	ebiten.SetWindowMousePassthrough(on)
+	if on {
+		a := g.actionByName("clickThrough")
+		g.notify(severityInfo, "Click-through is on, focus the window and press %s to turn it off", a.keys())
+	}
+}
+
+func (g *Game) changeWindowOpacity(step float64) {
+	if !desktop {
+		return
+	}
+	g.windowOpacity = math.Max(windowOpacityMin, math.Min(1, g.windowOpacity+step))
+	if g.windowOpacity >= 1 && g.canvas != nil {
+		g.canvas.Deallocate()
+		g.canvas = nil
+	}
+}
+
+func (g *Game) windowOpacityUpAction(selectedIndex int) {
+	g.changeWindowOpacity(windowOpacityStep)
+}
+
+func (g *Game) windowOpacityDownAction(selectedIndex int) {
+	g.changeWindowOpacity(-windowOpacityStep)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Searchable list of every registered action, opened with Ctrl+K
+type commandPalette struct {
+	query   string
+	cursor  int
+	scroll  int
+	results []*namedAction
+}
+
+const (
+	paletteWidth      = 480
+	paletteRows       = 10
+	paletteRowHeight  = 24
+	paletteFontSize   = 15
+	palettePadding    = 10
+	paletteTop        = 80
+	paletteRecentSize = 5
+)
+
+var (
+	colorPaletteQuery    = color.RGBA{55, 55, 55, 255}
+	colorPaletteCursor   = color.RGBA{0, 90, 200, 255}
+	colorPaletteText     = color.RGBA{235, 235, 235, 255}
+	colorPaletteDisabled = color.RGBA{120, 120, 120, 255}
+)
+
+// Runs the action from a key or the palette and remembers it as recently used
+func (g *Game) runAction(a *namedAction) {
+	a.run(g.selectedIndex)
+	if a.name == "palette" {
+		return
+	}
+
+	recent := []string{a.name}
+	for _, name := range g.recentActions {
+		if name != a.name && len(recent) < paletteRecentSize {
+			recent = append(recent, name)
+		}
+	}
+	g.recentActions = recent
+}
+
+func (a *namedAction) isEnabled() bool {
+	return a.enabled == nil || a.enabled()
+}
+
+// Scores the query as a subsequence of the target, matches at word starts and
+// runs of consecutive letters rank higher
+func fuzzyScore(query, target string) (int, bool) {
+	q := []rune(strings.ToLower(query))
+	t := []rune(strings.ToLower(target))
+	score, qi, last := 0, 0, -2
+	for ti := 0; ti < len(t) && qi < len(q); ti++ {
+		if t[ti] != q[qi] {
+			continue
+		}
+		score++
+		if ti == 0 || !unicode.IsLetter(t[ti-1]) {
+			score += 5
+		}
+		if last == ti-1 {
+			score += 3
+		}
+		last = ti
+		qi++
+	}
+	if qi < len(q) {
+		return 0, false
+	}
+	return score - len(t)/10, true // Shorter names win ties
+}
+
+// Recently used actions first without a query, best matches first otherwise
+func (g *Game) searchActions(query string) []*namedAction {
+	var results []*namedAction
+	if strings.TrimSpace(query) == "" {
+		for _, name := range g.recentActions {
+			if a := g.actionByName(name); a != nil {
+				results = append(results, a)
+			}
+		}
+		for i := range g.actions {
+			if a := &g.actions[i]; a.name != "palette" && !g.isRecent(a) {
+				results = append(results, a)
+			}
+		}
+		return results
+	}
+
+	scores := map[*namedAction]int{}
+	for i := range g.actions {
+		a := &g.actions[i]
+		if a.name == "palette" {
+			continue
+		}
+		best, found := 0, false
+		for _, target := range []string{a.description, a.name} {
+			if s, ok := fuzzyScore(query, target); ok && (!found || s > best) {
+				best, found = s, true
+			}
+		}
+		if found {
+			scores[a] = best
+			results = append(results, a)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return scores[results[i]] > scores[results[j]]
+	})
+	return results
+}
+
+func (g *Game) isRecent(a *namedAction) bool {
+	for _, name := range g.recentActions {
+		if name == a.name {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Game) setPaletteQuery(query string) {
+	p := g.palette
+	p.query = query
+	p.results = g.searchActions(query)
+	p.cursor = 0
+	p.scroll = 0
+}
+
+func (g *Game) movePaletteCursor(step int) {
+	p := g.palette
+	if len(p.results) == 0 {
+		return
+	}
+	p.cursor = (p.cursor + step + len(p.results)) % len(p.results)
+	if p.cursor < p.scroll {
+		p.scroll = p.cursor
+	} else if p.cursor >= p.scroll+paletteRows {
+		p.scroll = p.cursor - paletteRows + 1
+	}
+}
+
+// Closes the palette before running the action so it acts on the board
+func (g *Game) runPaletteAction(a *namedAction) {
+	if !a.isEnabled() {
+		g.notify(severityWarning, "%s is not available right now", a.description)
+		return
+	}
+	g.palette = nil
+	g.runAction(a)
+}
+
+func paletteBounds(screenWidth int, rows int) image.Rectangle {
+	x := (screenWidth - paletteWidth) / 2
+	h := (rows+1)*paletteRowHeight + 3*palettePadding
+	return image.Rect(x, paletteTop, x+paletteWidth, paletteTop+h)
+}
+
+// Row of the result under the cursor, -1 outside the list
+func (g *Game) paletteRowAt(x, y int) int {
+	p := g.palette
+	bounds := paletteBounds(g.uiWidth, paletteRows)
+	top := bounds.Min.Y + 2*palettePadding + paletteRowHeight
+	if x < bounds.Min.X || x >= bounds.Max.X || y < top {
+		return -1
+	}
+	row := p.scroll + (y-top)/paletteRowHeight
+	if row >= len(p.results) || row >= p.scroll+paletteRows {
+		return -1
+	}
+	return row
+}
+
+// The palette takes the keyboard and mouse while open
+func (g *Game) handlePalette(x, y int) {
+	p := g.palette
+	g.markDirty() // The caret blinks
+
+	g.inputChars = ebiten.AppendInputChars(g.inputChars[:0])
+	if len(g.inputChars) > 0 {
+		g.setPaletteQuery(p.query + string(g.inputChars))
+	}
+	if repeatingKeyPressed(ebiten.KeyBackspace) && len(p.query) > 0 {
+		r := []rune(p.query)
+		g.setPaletteQuery(string(r[:len(r)-1]))
+	}
+	if repeatingKeyPressed(ebiten.KeyDown) {
+		g.movePaletteCursor(1)
+	}
+	if repeatingKeyPressed(ebiten.KeyUp) {
+		g.movePaletteCursor(-1)
+	}
+	if _, wy := ebiten.Wheel(); wy > 0 {
+		g.movePaletteCursor(-1)
+	} else if wy < 0 {
+		g.movePaletteCursor(1)
+	}
+
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyEscape):
+		g.palette = nil
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyNumpadEnter):
+		if len(p.results) > 0 {
+			g.runPaletteAction(p.results[p.cursor])
+		}
+	case inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft):
+		row := g.paletteRowAt(x, y)
+		switch {
+		case row >= 0:
+			g.runPaletteAction(p.results[row])
+		case !image.Pt(x, y).In(paletteBounds(g.uiWidth, paletteRows)):
+			g.palette = nil
+		}
+	}
+}
+
+func (g *Game) drawPalette(screen *ebiten.Image) {
+	p := g.palette
+	bounds := paletteBounds(screen.Bounds().Dx(), paletteRows)
+	x, y := float32(bounds.Min.X), float32(bounds.Min.Y)
+	vector.DrawFilledRect(screen, x, y, float32(bounds.Dx()), float32(bounds.Dy()), g.theme().panel, false)
+	vector.DrawFilledRect(screen, x+palettePadding, y+palettePadding, paletteWidth-2*palettePadding, paletteRowHeight, colorPaletteQuery, false)
+
+	face := g.noteFace(paletteFontSize)
+	query := p.query
+	if g.ticks/30%2 == 0 { // Blinking caret
+		query += "|"
+	}
+	if p.query == "" {
+		query = "Search actions"
+	}
+	g.drawPaletteText(screen, face, query, bounds.Min.X+2*palettePadding, bounds.Min.Y+palettePadding, colorPaletteText, text.AlignStart)
+
+	top := bounds.Min.Y + 2*palettePadding + paletteRowHeight
+	for row := p.scroll; row < len(p.results) && row < p.scroll+paletteRows; row++ {
+		a := p.results[row]
+		ry := top + (row-p.scroll)*paletteRowHeight
+		if row == p.cursor {
+			vector.DrawFilledRect(screen, x+palettePadding, float32(ry), paletteWidth-2*palettePadding, paletteRowHeight, colorPaletteCursor, false)
+		}
+
+		clr := colorPaletteText
+		if !a.isEnabled() {
+			clr = colorPaletteDisabled
+		}
+		label := a.description
+		if p.query == "" && g.isRecent(a) {
+			label += "  (recent)"
+		}
+		g.drawPaletteText(screen, face, label, bounds.Min.X+2*palettePadding, ry, clr, text.AlignStart)
+		g.drawPaletteText(screen, face, a.keys(), bounds.Max.X-2*palettePadding, ry, clr, text.AlignEnd)
+	}
+	if len(p.results) == 0 {
+		g.drawPaletteText(screen, face, "No matching actions", bounds.Min.X+2*palettePadding, top, colorPaletteDisabled, text.AlignStart)
+	}
+}
+
+func (g *Game) drawPaletteText(screen *ebiten.Image, face text.Face, str string, x, y int, clr color.Color, align text.Align) {
+	op := &text.DrawOptions{}
+	op.GeoM.Translate(float64(x), float64(y)+float64(paletteRowHeight-paletteFontSize)/2-2)
+	op.ColorScale.ScaleWithColor(clr)
+	op.PrimaryAlign = align
+	text.Draw(screen, str, face, op)
+}
+
+func (g *Game) paletteAction(selectedIndex int) {
+	if g.palette != nil {
+		g.palette = nil
+		return
+	}
+	g.palette = &commandPalette{}
+	g.setPaletteQuery("")
+}
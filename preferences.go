@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Settings panel, a row per preference and a last one resetting them all
+type preferencesPanel struct {
+	cursor int
+	input  string // Number typed for the row under the cursor
+}
+
+// Value of the settings edited in the panel, toggles have no range
+type preference struct {
+	label    string
+	value    func(s *settings) *int
+	toggle   func(s *settings) *bool
+	min, max int
+	step     int
+	unit     string
+	names    []string // Shown in place of the number
+}
+
+var preferenceList = []preference{
+	{label: "Cursor debug", toggle: func(s *settings) *bool { return &s.CursorDebug }},
+	{label: "Action debug", toggle: func(s *settings) *bool { return &s.ActionDebug }},
+	{label: "FPS overlay", toggle: func(s *settings) *bool { return &s.FPSDebug }},
+	{label: "Memory overlay", toggle: func(s *settings) *bool { return &s.MemoryDebug }},
+	{label: "Eraser size", value: func(s *settings) *int { return &s.EraserSize }, min: sliderMin, max: sliderMax, step: brushSizeStep, unit: "px"},
+	{label: "Handle grab area", value: func(s *settings) *int { return &s.HandleArea }, min: 2, max: 32, step: 1, unit: "px"},
+	{label: "Handle size", value: func(s *settings) *int { return &s.HandleSize }, min: 2, max: 16, step: 1, unit: "px"},
+	{label: "Import at x", value: func(s *settings) *int { return &s.ImportX }, min: 0, max: 4000, step: 10, unit: "px"},
+	{label: "Import at y", value: func(s *settings) *int { return &s.ImportY }, min: 0, max: 4000, step: 10, unit: "px"},
+	{label: "Import cascade", value: func(s *settings) *int { return &s.ImportCascade }, min: 0, max: 200, step: 4, unit: "px"},
+	{label: "Proxy above", value: func(s *settings) *int { return &s.ProxyLimit }, min: 256, max: 16384, step: 256, unit: "px"},
+	{label: "Mip budget", value: func(s *settings) *int { return &s.MipBudget }, min: 16, max: 8192, step: 16, unit: "MB"},
+	{label: "Texture budget", value: func(s *settings) *int { return &s.TextureBudget }, min: 64, max: 16384, step: 64, unit: "MB"},
+	{label: "Image quality", value: func(s *settings) *int { return &s.LODQuality }, min: 0, max: len(lodNames) - 1, step: 1, names: lodNames},
+}
+
+func (p preference) format(s *settings) string {
+	if p.toggle != nil {
+		if *p.toggle(s) {
+			return "On"
+		}
+		return "Off"
+	}
+	v := *p.value(s)
+	if p.names != nil {
+		return p.names[v]
+	}
+	return fmt.Sprintf("%d %s", v, p.unit)
+}
+
+// Copies the preferences to where the rest of the game reads them
+func (g *Game) applyPreferences() {
+	s := g.settings
+	g.m.Lock()
+	g.proxyLimit = s.ProxyLimit // Read by the import goroutines
+	g.m.Unlock()
+	g.mipBudget = s.MipBudget << 20
+	g.textureBudget = s.TextureBudget << 20
+	g.lodQuality = s.LODQuality
+}
+
+func (g *Game) changePreferences(change func(s *settings)) {
+	g.m.Lock()
+	size := g.settings.EraserSize
+	change(&g.settings)
+	g.m.Unlock()
+	if g.settings.EraserSize != size { // Other rows leave the size picked on the sliders alone
+		g.sliderValue = g.settings.EraserSize
+	}
+	g.applyPreferences()
+	g.saveSettings()
+}
+
+func (g *Game) stepPreference(p preference, step int) {
+	g.changePreferences(func(s *settings) {
+		if p.toggle != nil {
+			*p.toggle(s) = !*p.toggle(s)
+			return
+		}
+		*p.value(s) = clamp(*p.value(s)+step*p.step, p.min, p.max)
+	})
+}
+
+// Applies the typed number, values out of range are refused rather than clamped
+func (g *Game) enterPreference(p preference, input string) bool {
+	v, err := strconv.Atoi(strings.TrimSpace(input))
+	if err != nil {
+		g.notify(severityWarning, "%s must be a whole number", p.label)
+		return false
+	}
+	if v < p.min || v > p.max {
+		g.notify(severityWarning, "%s must be between %d and %d", p.label, p.min, p.max)
+		return false
+	}
+	g.changePreferences(func(s *settings) {
+		*p.value(s) = v
+	})
+	return true
+}
+
+func (g *Game) resetPreferences() {
+	g.changePreferences(func(s *settings) {
+		d := defaultSettings()
+		for _, p := range preferenceList {
+			if p.toggle != nil {
+				*p.toggle(s) = *p.toggle(&d)
+			} else {
+				*p.value(s) = *p.value(&d)
+			}
+		}
+	})
+	g.notify(severityInfo, "Preferences reset to the defaults")
+}
+
+// Row under the cursor, -1 outside the list
+func (g *Game) preferenceRowAt(x, y int) int {
+	bounds := paletteBounds(g.uiWidth, len(preferenceList)+1)
+	top := bounds.Min.Y + 2*palettePadding + paletteRowHeight
+	if x < bounds.Min.X || x >= bounds.Max.X || y < top {
+		return -1
+	}
+	row := (y - top) / paletteRowHeight
+	if row > len(preferenceList) {
+		return -1
+	}
+	return row
+}
+
+// The panel takes the keyboard and mouse while open, numbers typed replace the value on Enter
+func (g *Game) handlePreferences(x, y int) {
+	p := g.preferences
+	g.markDirty() // The caret blinks
+	rows := len(preferenceList) + 1
+
+	g.inputChars = ebiten.AppendInputChars(g.inputChars[:0])
+	for _, c := range g.inputChars {
+		if (c >= '0' && c <= '9' || c == '-') && p.cursor < len(preferenceList) && preferenceList[p.cursor].value != nil {
+			p.input += string(c)
+		}
+	}
+	if repeatingKeyPressed(ebiten.KeyBackspace) && len(p.input) > 0 {
+		p.input = p.input[:len(p.input)-1]
+	}
+	if repeatingKeyPressed(ebiten.KeyDown) {
+		p.cursor, p.input = (p.cursor+1)%rows, ""
+	}
+	if repeatingKeyPressed(ebiten.KeyUp) {
+		p.cursor, p.input = (p.cursor+rows-1)%rows, ""
+	}
+	if p.cursor < len(preferenceList) && p.input == "" {
+		if repeatingKeyPressed(ebiten.KeyRight) {
+			g.stepPreference(preferenceList[p.cursor], 1)
+		}
+		if repeatingKeyPressed(ebiten.KeyLeft) {
+			g.stepPreference(preferenceList[p.cursor], -1)
+		}
+	}
+
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyEscape):
+		if p.input != "" {
+			p.input = ""
+		} else {
+			g.preferences = nil
+		}
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyNumpadEnter):
+		switch {
+		case p.cursor == len(preferenceList):
+			g.resetPreferences()
+		case p.input != "":
+			if g.enterPreference(preferenceList[p.cursor], p.input) {
+				p.input = ""
+			}
+		default:
+			g.stepPreference(preferenceList[p.cursor], 1)
+		}
+	case inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft):
+		row := g.preferenceRowAt(x, y)
+		switch {
+		case row == len(preferenceList):
+			g.resetPreferences()
+		case row >= 0 && row == p.cursor && preferenceList[row].toggle != nil:
+			g.stepPreference(preferenceList[row], 1)
+		case row >= 0:
+			p.cursor, p.input = row, ""
+		case !image.Pt(x, y).In(paletteBounds(g.uiWidth, rows)):
+			g.preferences = nil
+		}
+	}
+}
+
+func (g *Game) drawPreferences(screen *ebiten.Image) {
+	p := g.preferences
+	bounds := paletteBounds(screen.Bounds().Dx(), len(preferenceList)+1)
+	x, y := float32(bounds.Min.X), float32(bounds.Min.Y)
+	vector.DrawFilledRect(screen, x, y, float32(bounds.Dx()), float32(bounds.Dy()), g.theme().panel, false)
+
+	face := g.noteFace(paletteFontSize)
+	g.drawPaletteText(screen, face, "Preferences", bounds.Min.X+2*palettePadding, bounds.Min.Y+palettePadding, colorPaletteText, text.AlignStart)
+	g.drawPaletteText(screen, face, "Left/Right or type a number", bounds.Max.X-2*palettePadding, bounds.Min.Y+palettePadding, colorPaletteDisabled, text.AlignEnd)
+
+	top := bounds.Min.Y + 2*palettePadding + paletteRowHeight
+	for row := 0; row <= len(preferenceList); row++ {
+		ry := top + row*paletteRowHeight
+		if row == p.cursor {
+			vector.DrawFilledRect(screen, x+palettePadding, float32(ry), paletteWidth-2*palettePadding, paletteRowHeight, colorPaletteCursor, false)
+		}
+		if row == len(preferenceList) {
+			g.drawPaletteText(screen, face, "Reset to defaults", bounds.Min.X+2*palettePadding, ry, colorPaletteText, text.AlignStart)
+			continue
+		}
+
+		pref := preferenceList[row]
+		value := pref.format(&g.settings)
+		if row == p.cursor && p.input != "" {
+			value = p.input
+			if g.ticks/30%2 == 0 { // Blinking caret
+				value += "|"
+			}
+		}
+		g.drawPaletteText(screen, face, pref.label, bounds.Min.X+2*palettePadding, ry, colorPaletteText, text.AlignStart)
+		g.drawPaletteText(screen, face, value, bounds.Max.X-2*palettePadding, ry, colorPaletteText, text.AlignEnd)
+	}
+}
+
+func (g *Game) preferencesAction(selectedIndex int) {
+	if g.preferences != nil {
+		g.preferences = nil
+		return
+	}
+	g.palette = nil
+	g.preferences = &preferencesPanel{}
+}
@@ -0,0 +1,8 @@
+//go:build !js
+
+package main
+
+// Desktop builds get no pen pressure from the windowing backend
+func penPressure() float64 {
+	return 1
+}
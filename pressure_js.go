@@ -0,0 +1,32 @@
+//go:build js
+
+package main
+
+import (
+	"math"
+	"sync/atomic"
+	"syscall/js"
+)
+
+var pressureBits atomic.Uint64
+
+func init() {
+	pressureBits.Store(math.Float64bits(1))
+
+	update := js.FuncOf(func(this js.Value, args []js.Value) any {
+		e := args[0]
+		pressure := 1.0
+		if e.Get("pointerType").String() == "pen" {
+			pressure = e.Get("pressure").Float()
+		}
+		pressureBits.Store(math.Float64bits(pressure))
+		return nil
+	})
+	js.Global().Get("document").Call("addEventListener", "pointerdown", update)
+	js.Global().Get("document").Call("addEventListener", "pointermove", update)
+}
+
+// Pressure of the pen from pointer events, 1 for mice and touch
+func penPressure() float64 {
+	return math.Float64frombits(pressureBits.Load())
+}
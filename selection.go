@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+const (
+	selectNone    = 0
+	selectWand    = 1
+	selectLasso   = 2
+	selectPolygon = 3
+
+	selectionInfoYOffset = 12
+)
+
+var (
+	colorSelection     = color.RGBA{0, 90, 200, 110}
+	colorSelectionPath = color.RGBA{0, 90, 200, 255}
+)
+
+func (g *Game) clearSelection() {
+	g.selectMask = nil
+	g.selectImage = nil
+	g.selectPoints = nil
+	if g.selectOverlay != nil {
+		g.selectOverlay.Deallocate()
+		g.selectOverlay = nil
+	}
+}
+
+// The selection only applies to the image it was made on
+func (g *Game) selectionValid() bool {
+	return g.selectMask != nil && g.selected && g.visages[g.selectedIndex].image == g.selectImage
+}
+
+// Mask holds the coverage of each pixel of the image in 0-1
+func (g *Game) setSelection(img *ebiten.Image, mask []float32) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	g.selectMask = mask
+	g.selectImage = img
+
+	if g.selectOverlay == nil || g.selectOverlay.Bounds().Dx() != w || g.selectOverlay.Bounds().Dy() != h {
+		if g.selectOverlay != nil {
+			g.selectOverlay.Deallocate()
+		}
+		g.selectOverlay = ebiten.NewImage(w, h)
+	}
+
+	pix := make([]byte, 4*w*h)
+	c := colorSelection
+	for i, m := range mask {
+		a := float32(c.A) / 255 * m
+		pix[4*i] = uint8(float32(c.R) * a)
+		pix[4*i+1] = uint8(float32(c.G) * a)
+		pix[4*i+2] = uint8(float32(c.B) * a)
+		pix[4*i+3] = uint8(255 * a)
+	}
+	g.selectOverlay.WritePixels(pix)
+}
+
+func (g *Game) handleSelectTool(x, y int) {
+	if g.clicking || g.resizing {
+		return
+	}
+
+	sv := g.screenVisage(g.visages[g.selectedIndex]) // Selects as finely as the screen shows the image
+	v := &sv
+	pressed := inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft)
+
+	// Check if outofbounds + an offset for deselecting the tool
+	if pressed && (x < v.x-erasingOOBOffset || x > v.x+v.w+erasingOOBOffset || y < v.y-erasingOOBOffset || y > v.y+v.h+erasingOOBOffset) {
+		g.selectTool = selectNone
+		g.clearSelection()
+		return
+	}
+
+	switch g.selectTool {
+	case selectWand:
+		if pressed {
+			g.handleWand(v, x, y)
+		}
+	case selectLasso:
+		g.handleLasso(v, x, y, pressed)
+	case selectPolygon:
+		if pressed {
+			g.handlePolygon(v, x, y)
+		}
+	}
+}
+
+// Maps a point in image pixels back onto the screen
+func imageToScreen(v Visage, px, py int) (float32, float32) {
+	var geoM ebiten.GeoM
+	v.orient(&geoM, v.image.Bounds().Dx(), v.image.Bounds().Dy())
+	x, y := geoM.Apply(float64(px), float64(py))
+	return float32(x), float32(y)
+}
+
+func (g *Game) drawSelection(screen *ebiten.Image, v Visage) {
+	if g.selectionValid() {
+		op := &ebiten.DrawImageOptions{}
+		v.orient(&op.GeoM, g.selectOverlay.Bounds().Dx(), g.selectOverlay.Bounds().Dy())
+		screen.DrawImage(g.selectOverlay, op)
+	}
+
+	for i := 1; i < len(g.selectPoints); i++ {
+		x0, y0 := imageToScreen(v, g.selectPoints[i-1][0], g.selectPoints[i-1][1])
+		x1, y1 := imageToScreen(v, g.selectPoints[i][0], g.selectPoints[i][1])
+		vector.StrokeLine(screen, x0, y0, x1, y1, 1, colorSelectionPath, true)
+	}
+	if g.selectTool == selectPolygon && len(g.selectPoints) > 0 {
+		x0, y0 := imageToScreen(v, g.selectPoints[0][0], g.selectPoints[0][1])
+		vector.DrawFilledCircle(screen, x0, y0, float32(g.settings.HandleSize), colorSelectionPath, true)
+
+		x1, y1 := imageToScreen(v, g.selectPoints[len(g.selectPoints)-1][0], g.selectPoints[len(g.selectPoints)-1][1])
+		cx, cy := ebiten.CursorPosition()
+		vector.StrokeLine(screen, x1, y1, float32(cx), float32(cy), 1, colorSelectionPath, true)
+	}
+
+	info := fmt.Sprintf("Feather: %d", g.feather)
+	if g.selectTool == selectWand {
+		info = fmt.Sprintf("Tolerance: %d Feather: %d", g.wandTolerance, g.feather)
+	}
+	ebitenutil.DebugPrintAt(screen, info, v.x, v.y+v.h+selectionInfoYOffset)
+}
+
+func (g *Game) toggleSelectTool(tool int) {
+	if g.selectTool == tool {
+		g.selectTool = selectNone
+		g.clearSelection()
+		return
+	}
+
+	if len(g.visages) == 0 || !g.selected || g.visages[g.selectedIndex].image == nil || g.erasingToggle {
+		return
+	}
+	g.selectTool = tool
+	g.selectPoints = nil
+	g.wandHasColor = false
+}
+
+func (g *Game) wandAction(selectedIndex int) {
+	g.toggleSelectTool(selectWand)
+}
+
+func (g *Game) lassoAction(selectedIndex int) {
+	g.toggleSelectTool(selectLasso)
+}
+
+func (g *Game) polygonAction(selectedIndex int) {
+	g.toggleSelectTool(selectPolygon)
+}
+
+// Multiplies the alpha of the selected visage by the selection, or its inverse
+func (g *Game) eraseMasked(index int, outside bool) {
+	if g.selectTool == selectNone || !g.selectionValid() {
+		return
+	}
+
+	g.pushUndo(index)
+
+	v := &g.visages[index]
+	w, h := v.image.Bounds().Dx(), v.image.Bounds().Dy()
+	pix := make([]byte, 4*w*h)
+	v.image.ReadPixels(pix)
+	for i, m := range g.selectMask {
+		if outside {
+			scaleAlpha(pix, 4*i, float64(m))
+		} else {
+			scaleAlpha(pix, 4*i, float64(1-m))
+		}
+	}
+	v.image.WritePixels(pix)
+	g.clearSelection()
+}
+
+func (g *Game) eraseSelectionAction(selectedIndex int) {
+	g.eraseMasked(selectedIndex, false)
+}
+
+// Cuts the selection out by erasing everything around it
+func (g *Game) eraseOutsideAction(selectedIndex int) {
+	g.eraseMasked(selectedIndex, true)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"image/color"
+	"io/fs"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Appearance and preferences saved to the settings file whenever they change
+type settings struct {
+	Version    int         `json:"version"`
+	Theme      string      `json:"theme"`
+	Background *color.RGBA `json:"background,omitempty"` // Nil follows the theme
+	Pattern    string      `json:"pattern"`
+	UIScale    float64     `json:"uiScale,omitempty"` // Screen pixels per chrome pixel, 0 follows the monitor
+
+	CursorDebug   bool `json:"cursorDebug"`
+	ActionDebug   bool `json:"actionDebug"`
+	FPSDebug      bool `json:"fpsDebug"`
+	MemoryDebug   bool `json:"memoryDebug"`
+	EraserSize    int  `json:"eraserSize"`
+	HandleArea    int  `json:"handleArea"` // Pixels around a corner that grab it
+	HandleSize    int  `json:"handleSize"`
+	ImportX       int  `json:"importX"` // Where the first of the imported files goes
+	ImportY       int  `json:"importY"`
+	ImportCascade int  `json:"importCascade"` // Shift of each further file
+	ProxyLimit    int  `json:"proxyLimit"`
+	MipBudget     int  `json:"mipBudgetMB"`
+	TextureBudget int  `json:"textureBudgetMB"`
+	LODQuality    int  `json:"lodQuality"`
+}
+
+// UI colours, the panels stay dark in both themes as the debug font is white
+type theme struct {
+	background    color.RGBA
+	border        color.RGBA
+	handle        color.RGBA
+	handleOutline color.RGBA
+	button        color.RGBA
+	eraser        color.RGBA // Premultiplied like every colour ebiten draws
+	panel         color.RGBA
+}
+
+const (
+	settingsName    = "settings.json"
+	settingsVersion = 2 // Version 1 had no preferences
+	patternSolid    = "solid"
+	patternChecker  = "checkerboard"
+	patternDots     = "dots"
+	themeLight      = "light"
+	themeDark       = "dark"
+	checkerSize     = 12
+	dotSpacing      = 24
+	dotRadius       = 1.5
+	uiScaleMin      = 0.5
+	uiScaleMax      = 4
+	uiScaleStep     = 0.25
+)
+
+var themes = map[string]theme{
+	themeLight: {
+		background:    color.RGBA{240, 235, 230, 255},
+		border:        color.RGBA{0, 0, 0, 255},
+		handle:        color.RGBA{0, 0, 0, 255},
+		handleOutline: color.RGBA{255, 255, 255, 255},
+		button:        color.RGBA{0, 0, 0, 255},
+		eraser:        fadeColor(color.RGBA{255, 32, 78, 255}, 0.8),
+		panel:         color.RGBA{40, 40, 40, 235},
+	},
+	themeDark: {
+		background:    color.RGBA{45, 45, 48, 255},
+		border:        color.RGBA{235, 235, 235, 255},
+		handle:        color.RGBA{235, 235, 235, 255},
+		handleOutline: color.RGBA{0, 0, 0, 255},
+		button:        color.RGBA{60, 60, 64, 255},
+		eraser:        fadeColor(color.RGBA{255, 80, 110, 255}, 0.8),
+		panel:         color.RGBA{20, 20, 22, 235},
+	},
+}
+
+var patterns = []string{patternSolid, patternChecker, patternDots}
+
+// Backgrounds offered besides the theme's own
+var backgroundColors = []color.RGBA{
+	{120, 120, 120, 255},
+	{255, 255, 255, 255},
+	{0, 0, 0, 255},
+	{200, 215, 230, 255},
+}
+
+func defaultSettings() settings {
+	return settings{
+		Version: settingsVersion,
+		Theme:   themeLight,
+		Pattern: patternSolid,
+
+		EraserSize:    30,
+		HandleArea:    8,
+		HandleSize:    4,
+		ImportX:       40,
+		ImportY:       40,
+		ImportCascade: 24,
+		ProxyLimit:    proxyLimitDefault,
+		MipBudget:     mipBudgetDefault >> 20,
+		TextureBudget: textureBudgetDefault >> 20,
+		LODQuality:    lodSharp,
+	}
+}
+
+func (g *Game) theme() theme {
+	return themes[g.settings.Theme]
+}
+
+func (g *Game) backgroundColor() color.RGBA {
+	if g.settings.Background != nil {
+		return *g.settings.Background
+	}
+	return g.theme().background
+}
+
+// Unknown names fall back to the defaults and numbers are clamped into their
+// range, the files of older versions lack fields that keep the defaults decoded over
+func (s *settings) validate() {
+	d := defaultSettings()
+	if _, ok := themes[s.Theme]; !ok {
+		s.Theme = d.Theme
+	}
+	valid := false
+	for _, p := range patterns {
+		valid = valid || s.Pattern == p
+	}
+	if !valid {
+		s.Pattern = d.Pattern
+	}
+	if s.Background != nil {
+		s.Background.A = 255 // The board is opaque, see-through is the overlay toggle
+	}
+	if s.UIScale != 0 {
+		s.UIScale = math.Max(uiScaleMin, math.Min(uiScaleMax, s.UIScale))
+	}
+	for _, p := range preferenceList {
+		if p.value != nil {
+			*p.value(s) = clamp(*p.value(s), p.min, p.max)
+		}
+	}
+	s.Version = settingsVersion
+}
+
+func (g *Game) loadSettings() {
+	defer func() {
+		g.sliderValue = g.settings.EraserSize // The eraser starts at the saved size
+		g.applyPreferences()
+	}()
+	g.settings = defaultSettings()
+	data, err := readConfig(settingsName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		g.notify(severityWarning, "Failed to read %s: %v", settingsName, err)
+		return
+	}
+
+	s := defaultSettings()
+	if err := json.Unmarshal(data, &s); err != nil {
+		g.notify(severityWarning, "Failed to decode %s: %v", settingsName, err)
+		return
+	}
+	if s.Version > settingsVersion {
+		g.notify(severityWarning, "%s is from a newer version, using the default settings", settingsName)
+		return
+	}
+	s.validate()
+	g.settings = s
+}
+
+func (g *Game) saveSettings() {
+	data, err := json.MarshalIndent(g.settings, "", "  ")
+	if err == nil {
+		err = writeConfig(settingsName, data)
+	}
+	if err != nil {
+		g.notify(severityError, "Failed to save %s: %v", settingsName, err)
+	}
+}
+
+// Screen pixels per chrome pixel, the board is drawn at the device resolution
+func (g *Game) uiScale() float64 {
+	if g.settings.UIScale > 0 {
+		return g.settings.UIScale
+	}
+	return ebiten.Monitor().DeviceScaleFactor()
+}
+
+func (g *Game) drawBackground(screen *ebiten.Image) {
+	if g.transparent {
+		screen.Clear()
+		return
+	}
+
+	bg := g.backgroundColor()
+	screen.Fill(bg)
+	if g.settings.Pattern == patternSolid {
+		return
+	}
+
+	// A tile repeated over the screen, it moves along when panning
+	tile := g.backgroundTile(bg)
+	size := float32(tile.Bounds().Dx())
+	w, h := float32(screen.Bounds().Dx()), float32(screen.Bounds().Dy())
+	ox := float32(math.Mod(g.view.x, float64(size)))
+	oy := float32(math.Mod(g.view.y, float64(size)))
+	vertices := []ebiten.Vertex{
+		{DstX: 0, DstY: 0, SrcX: -ox, SrcY: -oy},
+		{DstX: w, DstY: 0, SrcX: w - ox, SrcY: -oy},
+		{DstX: 0, DstY: h, SrcX: -ox, SrcY: h - oy},
+		{DstX: w, DstY: h, SrcX: w - ox, SrcY: h - oy},
+	}
+	for i := range vertices {
+		vertices[i].ColorR, vertices[i].ColorG, vertices[i].ColorB, vertices[i].ColorA = 1, 1, 1, 1
+	}
+	op := &ebiten.DrawTrianglesOptions{Address: ebiten.AddressRepeat}
+	screen.DrawTriangles(vertices, []uint16{0, 1, 2, 1, 2, 3}, tile, op)
+}
+
+// Contrast colour for the pattern, darker on light backgrounds
+func patternColor(bg color.RGBA) color.RGBA {
+	if int(bg.R)+int(bg.G)+int(bg.B) > 3*128 {
+		return color.RGBA{bg.R / 5 * 4, bg.G / 5 * 4, bg.B / 5 * 4, 255}
+	}
+	return color.RGBA{bg.R/2 + 64, bg.G/2 + 64, bg.B/2 + 64, 255}
+}
+
+func (g *Game) backgroundTile(bg color.RGBA) *ebiten.Image {
+	if g.tile != nil && g.tileColor == bg && g.tilePattern == g.settings.Pattern {
+		return g.tile
+	}
+	if g.tile != nil {
+		g.tile.Deallocate()
+	}
+
+	fg := patternColor(bg)
+	switch g.settings.Pattern {
+	case patternChecker:
+		g.tile = ebiten.NewImage(2*checkerSize, 2*checkerSize)
+		g.tile.Fill(bg)
+		vector.DrawFilledRect(g.tile, 0, 0, checkerSize, checkerSize, fg, false)
+		vector.DrawFilledRect(g.tile, checkerSize, checkerSize, checkerSize, checkerSize, fg, false)
+	default:
+		g.tile = ebiten.NewImage(dotSpacing, dotSpacing)
+		g.tile.Fill(bg)
+		vector.DrawFilledCircle(g.tile, dotSpacing/2, dotSpacing/2, dotRadius, fg, true)
+	}
+	g.tileColor, g.tilePattern = bg, g.settings.Pattern
+	return g.tile
+}
+
+func (g *Game) themeAction(selectedIndex int) {
+	if g.settings.Theme == themeLight {
+		g.settings.Theme = themeDark
+	} else {
+		g.settings.Theme = themeLight
+	}
+	g.saveSettings()
+}
+
+func (g *Game) backgroundPatternAction(selectedIndex int) {
+	for i, p := range patterns {
+		if p == g.settings.Pattern {
+			g.settings.Pattern = patterns[(i+1)%len(patterns)]
+			break
+		}
+	}
+	g.saveSettings()
+}
+
+// Steps through the preset colours and back to the theme's background
+func (g *Game) backgroundColorAction(selectedIndex int) {
+	next := 0
+	if bg := g.settings.Background; bg != nil {
+		next = len(backgroundColors)
+		for i, c := range backgroundColors {
+			if c == *bg {
+				next = i + 1
+			}
+		}
+	}
+	if next < len(backgroundColors) {
+		c := backgroundColors[next]
+		g.settings.Background = &c
+	} else {
+		g.settings.Background = nil
+	}
+	g.saveSettings()
+}
+
+func (g *Game) changeUIScale(step float64) {
+	scale := math.Round((g.uiScale()+step)/uiScaleStep) * uiScaleStep
+	g.settings.UIScale = math.Max(uiScaleMin, math.Min(uiScaleMax, scale))
+	g.saveSettings()
+	g.notify(severityInfo, "UI scale %.0f%%", g.settings.UIScale*100)
+}
+
+func (g *Game) uiScaleUpAction(selectedIndex int) {
+	g.changeUIScale(uiScaleStep)
+}
+
+func (g *Game) uiScaleDownAction(selectedIndex int) {
+	g.changeUIScale(-uiScaleStep)
+}
+
+func (g *Game) uiScaleAutoAction(selectedIndex int) {
+	g.settings.UIScale = 0
+	g.saveSettings()
+	g.notify(severityInfo, "UI scale follows the monitor at %.0f%%", g.uiScale()*100)
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Shape is a vector annotation, lines and arrows run corner to corner of the visage
+type Shape struct {
+	kind        int
+	stroke      color.RGBA
+	strokeWidth float32
+	fill        color.RGBA // Transparent when unfilled
+	flipX       bool       // Line runs from the right edge
+	flipY       bool       // Line runs from the bottom edge
+	startID     int        // Visage the line starts at, 0 when unattached
+	endID       int
+}
+
+const (
+	shapeNone    = 0
+	shapeRect    = 1
+	shapeEllipse = 2
+	shapeLine    = 3
+	shapeArrow   = 4
+
+	strokeWidthMin     = 1
+	strokeWidthMax     = 32
+	strokeWidthDefault = 3
+	shapeMinSize       = 3
+	lineHitArea        = 6
+	ellipseSegments    = 64
+)
+
+var (
+	whiteImage    = ebiten.NewImage(3, 3)
+	whiteSubImage = whiteImage.SubImage(image.Rect(1, 1, 2, 2)).(*ebiten.Image)
+)
+
+func init() {
+	whiteImage.Fill(color.White)
+}
+
+func (s *Shape) clone() *Shape {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	return &c
+}
+
+func (s *Shape) isLine() bool {
+	return s.kind == shapeLine || s.kind == shapeArrow
+}
+
+func (v Visage) lineEnds() (x0, y0, x1, y1 float64) {
+	x0, y0 = float64(v.x), float64(v.y)
+	x1, y1 = float64(v.x+v.w), float64(v.y+v.h)
+	if v.shape.flipX {
+		x0, x1 = x1, x0
+	}
+	if v.shape.flipY {
+		y0, y1 = y1, y0
+	}
+	return x0, y0, x1, y1
+}
+
+func (v *Visage) setLineEnds(x0, y0, x1, y1 float64) {
+	v.x = int(math.Round(math.Min(x0, x1)))
+	v.y = int(math.Round(math.Min(y0, y1)))
+	v.w = int(math.Round(math.Abs(x1 - x0)))
+	v.h = int(math.Round(math.Abs(y1 - y0)))
+	v.shape.flipX = x1 < x0
+	v.shape.flipY = y1 < y0
+}
+
+// Takes g.m as the import goroutines ask for ids too
+func (g *Game) newID() int {
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.nextID++
+	return g.nextID
+}
+
+func (g *Game) visageIndexByID(id int) int {
+	for i, v := range g.visages {
+		if v.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Reports whether the point is on the visage, lines only count near the stroke
+func (g *Game) hitVisage(v Visage, x, y int) bool {
+	if v.hidden {
+		return false
+	}
+	if v.shape != nil && v.shape.isLine() {
+		x0, y0, x1, y1 := v.lineEnds()
+		allow := math.Max(lineHitArea/g.view.zoom, float64(v.shape.strokeWidth)) // The hit area keeps its size on screen
+		return distanceToSegment(float64(x), float64(y), x0, y0, x1, y1) <= allow
+	}
+	return x >= v.x && x <= v.x+v.w && y >= v.y && y <= v.y+v.h
+}
+
+func distanceToSegment(px, py, x0, y0, x1, y1 float64) float64 {
+	dx, dy := x1-x0, y1-y0
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, ((px-x0)*dx+(py-y0)*dy)/l))
+	}
+	return math.Hypot(px-(x0+t*dx), py-(y0+t*dy))
+}
+
+// Moves a point at the center of a visage out along the line to its border
+func clipToBorder(v Visage, cx, cy, towardX, towardY float64) (float64, float64) {
+	dx, dy := towardX-cx, towardY-cy
+	t := math.Inf(1)
+	if dx != 0 {
+		t = math.Min(t, float64(v.w)/2/math.Abs(dx))
+	}
+	if dy != 0 {
+		t = math.Min(t, float64(v.h)/2/math.Abs(dy))
+	}
+	if math.IsInf(t, 1) || t > 1 {
+		return cx, cy
+	}
+	return cx + dx*t, cy + dy*t
+}
+
+func visageCenter(v Visage) (float64, float64) {
+	return float64(v.x) + float64(v.w)/2, float64(v.y) + float64(v.h)/2
+}
+
+// Keeps attached lines following the visages they connect
+func (g *Game) updateShapeAttachments() {
+	for i := range g.visages {
+		v := &g.visages[i]
+		if v.shape == nil || !v.shape.isLine() || (v.shape.startID == 0 && v.shape.endID == 0) {
+			continue
+		}
+		if g.selected && g.selectedIndex == i && (g.dragging || g.resizing) {
+			continue
+		}
+
+		x0, y0, x1, y1 := v.lineEnds()
+		start, end := g.visageIndexByID(v.shape.startID), g.visageIndexByID(v.shape.endID)
+		if start < 0 {
+			v.shape.startID = 0
+		} else {
+			x0, y0 = visageCenter(g.visages[start])
+		}
+		if end < 0 {
+			v.shape.endID = 0
+		} else {
+			x1, y1 = visageCenter(g.visages[end])
+		}
+		if start >= 0 {
+			x0, y0 = clipToBorder(g.visages[start], x0, y0, x1, y1)
+		}
+		if end >= 0 {
+			x1, y1 = clipToBorder(g.visages[end], x1, y1, x0, y0)
+		}
+		v.setLineEnds(x0, y0, x1, y1)
+	}
+}
+
+// Attaches the ends of a line to the topmost visage under each of them
+func (g *Game) attachShapeEnds(index int) {
+	v := &g.visages[index]
+	if v.shape == nil || !v.shape.isLine() {
+		return
+	}
+
+	x0, y0, x1, y1 := v.lineEnds()
+	v.shape.startID = g.attachableAt(index, x0, y0)
+	v.shape.endID = g.attachableAt(index, x1, y1)
+	if v.shape.startID != 0 && v.shape.startID == v.shape.endID {
+		v.shape.endID = 0
+	}
+}
+
+func (g *Game) attachableAt(index int, x, y float64) int {
+	hits := g.visagesAt(int(x), int(y))
+	for j := len(hits) - 1; j >= 0; j-- {
+		i := hits[j]
+		v := g.visages[i]
+		if i == index || v.shape != nil || v.hidden {
+			continue
+		}
+		if x >= float64(v.x) && x <= float64(v.x+v.w) && y >= float64(v.y) && y <= float64(v.y+v.h) {
+			return v.id
+		}
+	}
+	return 0
+}
+
+// Starts a new shape under the cursor which is then sized with the bottom right handle
+func (g *Game) startShape(x, y int) {
+	g.visages = append(g.visages, Visage{
+		id: g.newID(),
+		x:  x,
+		y:  y,
+		shape: &Shape{
+			kind:        g.shapeTool,
+			stroke:      noteColors[0],
+			strokeWidth: strokeWidthDefault,
+		},
+	})
+	g.selected = true
+	g.selectedIndex = len(g.visages) - 1
+	g.resizing = true
+	g.resizeHandle = handleBottomRight
+	g.shapeTool = shapeNone
+	g.creatingShape = true
+}
+
+// Called on resize release, a negative size flips the direction of lines
+func (g *Game) finishShape() {
+	v := &g.visages[g.selectedIndex]
+	if v.w < 0 {
+		v.x += v.w
+		v.w = -v.w
+		v.shape.flipX = !v.shape.flipX
+	}
+	if v.h < 0 {
+		v.y += v.h
+		v.h = -v.h
+		v.shape.flipY = !v.shape.flipY
+	}
+
+	creating := g.creatingShape
+	g.creatingShape = false
+	if creating && v.w < shapeMinSize && v.h < shapeMinSize { // Clicked without dragging
+		g.visages = append(g.visages[:g.selectedIndex], g.visages[g.selectedIndex+1:]...)
+		g.selected = false
+		return
+	}
+
+	g.attachShapeEnds(g.selectedIndex)
+}
+
+func (g *Game) drawShape(screen *ebiten.Image, v Visage) {
+	s := v.shape
+	x, y, w, h := float32(v.x), float32(v.y), float32(v.w), float32(v.h)
+
+	switch s.kind {
+	case shapeRect:
+		if s.fill.A > 0 {
+			vector.DrawFilledRect(screen, x, y, w, h, s.fill, true)
+		}
+		vector.StrokeRect(screen, x, y, w, h, s.strokeWidth, s.stroke, true)
+	case shapeEllipse:
+		path := ellipsePath(x+w/2, y+h/2, w/2, h/2)
+		if s.fill.A > 0 {
+			drawPath(screen, path, nil, s.fill)
+		}
+		drawPath(screen, path, &vector.StrokeOptions{Width: s.strokeWidth}, s.stroke)
+	case shapeLine, shapeArrow:
+		x0, y0, x1, y1 := v.lineEnds()
+		if s.kind == shapeArrow {
+			x1, y1 = drawArrowHead(screen, s, x0, y0, x1, y1)
+		}
+		vector.StrokeLine(screen, float32(x0), float32(y0), float32(x1), float32(y1), s.strokeWidth, s.stroke, true)
+	}
+}
+
+func arrowHeadSize(s *Shape) float64 {
+	return math.Max(10, float64(s.strokeWidth)*4)
+}
+
+// Draws the head at the end of the line and returns where the shaft should stop
+func drawArrowHead(screen *ebiten.Image, s *Shape, x0, y0, x1, y1 float64) (float64, float64) {
+	length := math.Hypot(x1-x0, y1-y0)
+	if length == 0 {
+		return x1, y1
+	}
+
+	size := math.Min(arrowHeadSize(s), length)
+	ux, uy := (x1-x0)/length, (y1-y0)/length
+	bx, by := x1-ux*size, y1-uy*size
+	half := size / 2
+
+	var path vector.Path
+	path.MoveTo(float32(x1), float32(y1))
+	path.LineTo(float32(bx-uy*half), float32(by+ux*half))
+	path.LineTo(float32(bx+uy*half), float32(by-ux*half))
+	path.Close()
+	drawPath(screen, &path, nil, s.stroke)
+
+	return bx + ux*size/4, by + uy*size/4
+}
+
+func ellipsePath(cx, cy, rx, ry float32) *vector.Path {
+	var path vector.Path
+	for i := 0; i < ellipseSegments; i++ {
+		a := 2 * math.Pi * float64(i) / ellipseSegments
+		px := cx + rx*float32(math.Cos(a))
+		py := cy + ry*float32(math.Sin(a))
+		if i == 0 {
+			path.MoveTo(px, py)
+		} else {
+			path.LineTo(px, py)
+		}
+	}
+	path.Close()
+	return &path
+}
+
+// Fills the path, or strokes it when stroke options are given
+func drawPath(screen *ebiten.Image, path *vector.Path, stroke *vector.StrokeOptions, clr color.Color) {
+	var vs []ebiten.Vertex
+	var is []uint16
+	if stroke != nil {
+		vs, is = path.AppendVerticesAndIndicesForStroke(nil, nil, stroke)
+	} else {
+		vs, is = path.AppendVerticesAndIndicesForFilling(nil, nil)
+	}
+
+	r, g, b, a := clr.RGBA()
+	for i := range vs {
+		vs[i].SrcX = 1
+		vs[i].SrcY = 1
+		vs[i].ColorR = float32(r) / 0xffff
+		vs[i].ColorG = float32(g) / 0xffff
+		vs[i].ColorB = float32(b) / 0xffff
+		vs[i].ColorA = float32(a) / 0xffff
+	}
+
+	op := &ebiten.DrawTrianglesOptions{}
+	op.ColorScaleMode = ebiten.ColorScaleModePremultipliedAlpha
+	op.AntiAlias = true
+	if stroke == nil {
+		op.FillRule = ebiten.NonZero
+	}
+	screen.DrawTriangles(vs, is, whiteSubImage, op)
+}
+
+// Extra room the stroke and arrow head take outside the visage bounds
+func shapeOverhang(s *Shape) int {
+	overhang := float64(s.strokeWidth) / 2
+	if s.kind == shapeArrow {
+		overhang = math.Max(overhang, arrowHeadSize(s)/2)
+	}
+	return int(math.Ceil(overhang))
+}
+
+func (g *Game) selectedShape() *Shape {
+	if len(g.visages) == 0 || !g.selected {
+		return nil
+	}
+	return g.visages[g.selectedIndex].shape
+}
+
+func (g *Game) selectShapeTool(kind int) {
+	if g.erasingToggle {
+		return
+	}
+
+	if g.shapeTool == kind {
+		g.shapeTool = shapeNone
+	} else {
+		g.shapeTool = kind
+	}
+}
+
+func (g *Game) rectToolAction(selectedIndex int) {
+	g.selectShapeTool(shapeRect)
+}
+
+func (g *Game) ellipseToolAction(selectedIndex int) {
+	g.selectShapeTool(shapeEllipse)
+}
+
+func (g *Game) lineToolAction(selectedIndex int) {
+	g.selectShapeTool(shapeLine)
+}
+
+func (g *Game) arrowToolAction(selectedIndex int) {
+	g.selectShapeTool(shapeArrow)
+}
+
+func (g *Game) fillAction(selectedIndex int) {
+	s := g.selectedShape()
+	if s == nil || s.isLine() {
+		return
+	}
+
+	if s.fill.A > 0 {
+		s.fill = color.RGBA{}
+	} else { // Translucent fill in the stroke colour, premultiplied
+		s.fill = color.RGBA{s.stroke.R / 3, s.stroke.G / 3, s.stroke.B / 3, 255 / 3}
+	}
+}
+
+func (g *Game) cancelToolAction(selectedIndex int) {
+	g.shapeTool = shapeNone
+	g.group = nil
+	g.clearSelection()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"image"
+	"sort"
+)
+
+// Uniform grid over board coordinates, cells hold indices into g.visages
+type spatialIndex struct {
+	cells  map[[2]int][]int
+	ids    []int
+	bounds []image.Rectangle
+	keys   []boundsKey
+	seen   []int // Stamp of the last query that returned the index
+	stamp  int
+}
+
+// Everything visageBounds depends on, the bounds are only measured again when it changes
+type boundsKey struct {
+	x, y, w, h int
+	shape      Shape
+	captioned  bool
+	caption    string
+	size       float64
+}
+
+const gridCellSize = 256
+
+func (g *Game) boundsKey(v Visage) boundsKey {
+	k := boundsKey{x: v.x, y: v.y, w: v.w, h: v.h}
+	if v.shape != nil {
+		k.shape = *v.shape
+	}
+	if v.caption != nil {
+		k.captioned, k.caption, k.size = true, g.noteText(v.caption), v.caption.size
+	}
+	return k
+}
+
+func floorDiv(a, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
+
+func cellRange(r image.Rectangle) (int, int, int, int) {
+	return floorDiv(r.Min.X, gridCellSize), floorDiv(r.Min.Y, gridCellSize), floorDiv(r.Max.X, gridCellSize), floorDiv(r.Max.Y, gridCellSize)
+}
+
+func (s *spatialIndex) insert(i int, r image.Rectangle) {
+	x0, y0, x1, y1 := cellRange(r)
+	for cy := y0; cy <= y1; cy++ {
+		for cx := x0; cx <= x1; cx++ {
+			key := [2]int{cx, cy}
+			s.cells[key] = append(s.cells[key], i)
+		}
+	}
+}
+
+func (s *spatialIndex) remove(i int, r image.Rectangle) {
+	x0, y0, x1, y1 := cellRange(r)
+	for cy := y0; cy <= y1; cy++ {
+		for cx := x0; cx <= x1; cx++ {
+			key := [2]int{cx, cy}
+			cell := s.cells[key]
+			for j, k := range cell {
+				if k == i {
+					cell = append(cell[:j], cell[j+1:]...)
+					break
+				}
+			}
+			if len(cell) == 0 {
+				delete(s.cells, key)
+			} else {
+				s.cells[key] = cell
+			}
+		}
+	}
+}
+
+// Moves the visages whose bounds changed since the last sync, deleting or
+// reordering visages shifts the indices so the grid is built again
+func (g *Game) syncIndex() {
+	s := &g.index
+	rebuild := s.cells == nil || len(g.visages) < len(s.ids)
+	for i := 0; !rebuild && i < len(s.ids); i++ {
+		rebuild = s.ids[i] != g.visages[i].id
+	}
+	if rebuild {
+		s.cells = map[[2]int][]int{}
+		s.ids = s.ids[:0]
+		s.bounds = s.bounds[:0]
+		s.keys = s.keys[:0]
+	}
+
+	for i, v := range g.visages {
+		k := g.boundsKey(v)
+		if i >= len(s.ids) {
+			r := g.visageBounds(v)
+			s.ids = append(s.ids, v.id)
+			s.bounds = append(s.bounds, r)
+			s.keys = append(s.keys, k)
+			s.insert(i, r)
+			continue
+		}
+		if s.keys[i] == k {
+			continue
+		}
+		r := g.visageBounds(v)
+		s.keys[i] = k
+		if s.bounds[i] != r {
+			s.remove(i, s.bounds[i])
+			s.bounds[i] = r
+			s.insert(i, r)
+		}
+	}
+}
+
+// Indices of the visages overlapping the rectangle in drawing order
+func (g *Game) visagesIn(r image.Rectangle) []int {
+	s := &g.index
+	s.stamp++
+	for len(s.seen) < len(s.ids) {
+		s.seen = append(s.seen, 0)
+	}
+
+	var found []int
+	x0, y0, x1, y1 := cellRange(r)
+	for cy := y0; cy <= y1; cy++ {
+		for cx := x0; cx <= x1; cx++ {
+			for _, i := range s.cells[[2]int{cx, cy}] {
+				if s.seen[i] == s.stamp || i >= len(g.visages) {
+					continue
+				}
+				s.seen[i] = s.stamp
+				if s.bounds[i].Overlaps(r) {
+					found = append(found, i)
+				}
+			}
+		}
+	}
+	sort.Ints(found)
+	return found
+}
+
+// Candidates for a hit test at the point, borders count as inside
+func (g *Game) visagesAt(x, y int) []int {
+	return g.visagesIn(image.Rect(x-1, y-1, x+2, y+2))
+}
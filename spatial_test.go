@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+var benchmarkSizes = []int{100, 1000, 10000}
+
+// Board of n visages scattered at the same density whatever n
+func benchmarkGame(n int) *Game {
+	g := &Game{}
+	rng := rand.New(rand.NewSource(1))
+	side := int(math.Sqrt(float64(n))) * 300
+	for i := 0; i < n; i++ {
+		g.visages = append(g.visages, Visage{
+			id: i + 1,
+			x:  rng.Intn(side),
+			y:  rng.Intn(side),
+			w:  50 + rng.Intn(250),
+			h:  50 + rng.Intn(250),
+		})
+	}
+	g.syncIndex()
+	return g
+}
+
+// What the index replaces, every visage tested in turn
+func linearVisagesIn(g *Game, r image.Rectangle) []int {
+	var found []int
+	for i, v := range g.visages {
+		if g.visageBounds(v).Overlaps(r) {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+func benchmarkPoints(g *Game) []image.Point {
+	rng := rand.New(rand.NewSource(2))
+	side := int(math.Sqrt(float64(len(g.visages)))) * 300
+	points := make([]image.Point, 1024)
+	for i := range points {
+		points[i] = image.Pt(rng.Intn(side), rng.Intn(side))
+	}
+	return points
+}
+
+func BenchmarkVisagesAt(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		g := benchmarkGame(n)
+		points := benchmarkPoints(g)
+		b.Run(fmt.Sprintf("grid/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := points[i%len(points)]
+				g.visagesAt(p.X, p.Y)
+			}
+		})
+		b.Run(fmt.Sprintf("linear/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := points[i%len(points)]
+				linearVisagesIn(g, image.Rect(p.X-1, p.Y-1, p.X+2, p.Y+2))
+			}
+		})
+	}
+}
+
+// Queries the size of a 1920x1080 screen, as culling does every frame
+func BenchmarkVisagesIn(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		g := benchmarkGame(n)
+		points := benchmarkPoints(g)
+		b.Run(fmt.Sprintf("grid/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := points[i%len(points)]
+				g.visagesIn(image.Rect(p.X, p.Y, p.X+1920, p.Y+1080))
+			}
+		})
+		b.Run(fmt.Sprintf("linear/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := points[i%len(points)]
+				linearVisagesIn(g, image.Rect(p.X, p.Y, p.X+1920, p.Y+1080))
+			}
+		})
+	}
+}
+
+func TestVisagesInMatchesLinearScan(t *testing.T) {
+	g := benchmarkGame(1000)
+	for _, p := range benchmarkPoints(g) {
+		r := image.Rect(p.X, p.Y, p.X+640, p.Y+480)
+		if got, want := g.visagesIn(r), linearVisagesIn(g, r); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("visagesIn(%v) = %v, want %v", r, got, want)
+		}
+	}
+
+	g.visages[10].x += 5000 // The index follows a moved visage
+	g.syncIndex()
+	r := g.visageBounds(g.visages[10])
+	if got := g.visagesIn(r); len(got) == 0 || fmt.Sprint(got) != fmt.Sprint(linearVisagesIn(g, r)) {
+		t.Fatalf("moved visage not found at %v: %v", r, got)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build !js
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var errNoFilePicker = errors.New("drop images or a board onto the window to import them")
+
+func writeFile(name string, data []byte) error {
+	return os.WriteFile(name, data, 0o644)
+}
+
+// Reads a file from the visage directory in the user config directory
+func readConfig(name string) ([]byte, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(dir, "visage", name))
+}
+
+func writeConfig(name string, data []byte) error {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return err
+	}
+	dir = filepath.Join(dir, "visage")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name), data, 0o644)
+}
+
+// There is no native file dialog, files are dropped onto the window instead
+func pickFiles(open func(fs.FS)) error {
+	return errNoFilePicker
+}
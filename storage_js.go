@@ -0,0 +1,79 @@
+//go:build js
+
+package main
+
+import (
+	"io/fs"
+	"syscall/js"
+	"testing/fstest"
+	"time"
+)
+
+// Browsers can't write to disk, the file is offered as a download instead
+func writeFile(name string, data []byte) error {
+	array := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(array, data)
+	blob := js.Global().Get("Blob").New([]any{array})
+	url := js.Global().Get("URL").Call("createObjectURL", blob)
+	defer js.Global().Get("URL").Call("revokeObjectURL", url)
+
+	a := js.Global().Get("document").Call("createElement", "a")
+	a.Set("href", url)
+	a.Set("download", name)
+	a.Call("click")
+	return nil
+}
+
+// Config files live in local storage under the visage prefix
+func readConfig(name string) ([]byte, error) {
+	item := js.Global().Get("localStorage").Call("getItem", "visage/"+name)
+	if item.IsNull() {
+		return nil, fs.ErrNotExist
+	}
+	return []byte(item.String()), nil
+}
+
+func writeConfig(name string, data []byte) error {
+	js.Global().Get("localStorage").Call("setItem", "visage/"+name, string(data))
+	return nil
+}
+
+// Opens the browser file picker and hands the chosen files over once they are read
+func pickFiles(open func(fs.FS)) error {
+	input := js.Global().Get("document").Call("createElement", "input")
+	input.Set("type", "file")
+	input.Set("multiple", true)
+	input.Set("accept", "image/*,.svg,"+boardExt)
+
+	var onChange js.Func
+	onChange = js.FuncOf(func(this js.Value, args []js.Value) any {
+		onChange.Release()
+		files := input.Get("files")
+		n := files.Length()
+		fsys := fstest.MapFS{}
+		pending := n
+		for i := 0; i < n; i++ {
+			file := files.Index(i)
+			var onLoad js.Func
+			onLoad = js.FuncOf(func(this js.Value, args []js.Value) any {
+				onLoad.Release()
+				array := js.Global().Get("Uint8Array").New(args[0])
+				data := make([]byte, array.Length())
+				js.CopyBytesToGo(data, array)
+				fsys[file.Get("name").String()] = &fstest.MapFile{
+					Data:    data,
+					ModTime: time.UnixMilli(int64(file.Get("lastModified").Float())),
+				}
+				if pending--; pending == 0 {
+					go open(fsys) // Callbacks must not block
+				}
+				return nil
+			})
+			file.Call("arrayBuffer").Call("then", onLoad)
+		}
+		return nil
+	})
+	input.Call("addEventListener", "change", onChange)
+	input.Call("click")
+	return nil
+}
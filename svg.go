@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"sync"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// Source of a visage dropped as SVG, rasterised to match its size on screen
+type vectorImage struct {
+	m    sync.Mutex // The icon transform is changed while rasterising
+	data []byte
+	icon *oksvg.SvgIcon
+}
+
+const (
+	transformFlipHorizontal = 1
+	transformFlipVertical   = 2
+	transformRotate         = 3
+
+	svgExt         = ".svg"
+	svgDefaultSize = 512
+	svgMaxSize     = 4096
+)
+
+func parseSVG(data []byte) (*vectorImage, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.WarnErrorMode)
+	if err != nil {
+		return nil, err
+	}
+	if icon.ViewBox.W <= 0 || icon.ViewBox.H <= 0 {
+		icon.ViewBox.W = svgDefaultSize
+		icon.ViewBox.H = svgDefaultSize
+	}
+	return &vectorImage{
+		data: data,
+		icon: icon,
+	}, nil
+}
+
+// Parses its own icon so the copies rasterise independently
+func (s *vectorImage) clone() *vectorImage {
+	if s == nil {
+		return nil
+	}
+	c, err := parseSVG(s.data)
+	if err != nil {
+		return nil
+	}
+	return c
+}
+
+// Natural size of the SVG in pixels
+func (s *vectorImage) size() (int, int) {
+	return int(math.Ceil(s.icon.ViewBox.W)), int(math.Ceil(s.icon.ViewBox.H))
+}
+
+func (s *vectorImage) rasterize(w, h int) *image.RGBA {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	s.icon.SetTarget(0, 0, float64(w), float64(h))
+	scanner := rasterx.NewScannerGV(w, h, img, img.Bounds())
+	s.icon.Draw(rasterx.NewDasher(w, h, scanner), 1)
+	return img
+}
+
+// Rasterises at the given on-screen size
+func (s *vectorImage) render(w, h int) *ebiten.Image {
+	return ebiten.NewImageFromImage(s.rasterize(clampSize(w), clampSize(h)))
+}
+
+func clampSize(size int) int {
+	if size < 1 {
+		return 1
+	}
+	if size > svgMaxSize {
+		return svgMaxSize
+	}
+	return size
+}
+
+func transformImage(img *ebiten.Image, transform int) *ebiten.Image {
+	switch transform {
+	case transformFlipHorizontal:
+		return flipHorizontal(img)
+	case transformFlipVertical:
+		return flipVertical(img)
+	case transformRotate:
+		return rotateClockwise(img)
+	}
+	return img
+}
+
+// Applies the transforms in order, freeing the textures in between
+func transformAll(img *ebiten.Image, transforms []int) *ebiten.Image {
+	for _, t := range transforms {
+		next := transformImage(img, t)
+		img.Deallocate()
+		img = next
+	}
+	return img
+}
+
+// Rasterises the SVG again when the visage is shown larger than its raster
+func (g *Game) refreshVectorImage(index int) {
+	v := g.screenVisage(g.visages[index])
+	if v.svg == nil {
+		return
+	}
+	w, h := v.unturned()
+	if w <= v.image.Bounds().Dx() && h <= v.image.Bounds().Dy() {
+		return
+	}
+
+	svg, id := v.svg, v.id
+	go func() {
+		img := svg.render(w, h)
+
+		g.m.Lock()
+		defer g.m.Unlock()
+		if i := g.visageIndexByID(id); i >= 0 && g.visages[i].svg == svg {
+			g.visages[i].replaceImage(img)
+			g.visages[i].evicted = nil
+			g.markDirty()
+		}
+	}()
+}
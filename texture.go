@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Pixels of an offscreen visage whose texture was deallocated, the image keeps
+// its pointer and gets the pixels written back once it is needed again
+type evictedTexture struct {
+	w, h int
+	raw  []byte // Until the compression finished, guarded by g.m like data
+	data []byte // Deflate compressed RGBA
+}
+
+const (
+	textureBudgetDefault = 512 << 20 // Bytes of visage textures kept on the GPU
+	memoryDebugTop       = 5
+)
+
+func imageBytes(img *ebiten.Image) int {
+	if img == nil {
+		return 0
+	}
+	return 4 * img.Bounds().Dx() * img.Bounds().Dy()
+}
+
+// GPU memory held by the visage including its mip levels
+func textureBytes(v Visage) int {
+	if v.evicted != nil {
+		return 0
+	}
+	size := 0
+	if v.anim != nil {
+		for _, frame := range v.anim.frames {
+			size += imageBytes(frame)
+		}
+	} else {
+		size += imageBytes(v.image)
+	}
+	if v.mips != nil {
+		size += v.mips.bytes()
+	}
+	return size
+}
+
+// Deallocates the textures of a visage that was removed or replaced
+func releaseVisage(v Visage) {
+	if v.anim != nil {
+		for _, frame := range v.anim.frames {
+			frame.Deallocate()
+		}
+	} else if v.image != nil && v.detachImage() {
+		v.image.Deallocate()
+	}
+	if v.mips != nil {
+		v.mips.release()
+	}
+}
+
+// Swaps the image of a visage for a new one and frees the old texture unless it is shared
+func (v *Visage) replaceImage(img *ebiten.Image) {
+	if v.image != nil && v.image != img && v.detachImage() {
+		v.image.Deallocate()
+	}
+	v.image = img
+}
+
+// GPU memory of all visages, shared textures are counted once
+func (g *Game) textureUsage() int {
+	usage := 0
+	counted := map[*ebiten.Image]bool{}
+	for _, v := range g.visages {
+		if v.shared != nil {
+			if counted[v.image] {
+				continue
+			}
+			counted[v.image] = true
+		}
+		usage += textureBytes(v)
+	}
+	return usage
+}
+
+// Brings back evicted visages on screen or selected and evicts offscreen ones over the budget
+func (g *Game) updateTextures() {
+	for i := range g.visages { // The last one left of a share group owns the texture again
+		if v := &g.visages[i]; v.shared != nil && v.shared.refs == 1 {
+			v.shared = nil
+			v.linked = false
+		}
+	}
+
+	g.syncIndex()
+	screen := g.view.boardRect(image.Rect(0, 0, g.screenWidth, g.screenHeight))
+	for _, i := range g.visagesIn(screen) {
+		g.visages[i].seen = g.ticks
+		g.restoreTexture(i)
+	}
+	if g.selected {
+		g.restoreTexture(g.selectedIndex)
+	}
+
+	// Shared textures may still be on screen through another visage
+	usage := g.textureUsage()
+	var candidates []int
+	for i, v := range g.visages {
+		if v.seen != g.ticks && (!g.selected || g.selectedIndex != i) && v.evicted == nil && v.anim == nil && v.image != nil && v.shared == nil {
+			candidates = append(candidates, i)
+		}
+	}
+	sort.Slice(candidates, func(a, b int) bool {
+		return g.visages[candidates[a]].seen < g.visages[candidates[b]].seen
+	})
+
+	for _, i := range candidates {
+		if usage <= g.textureBudget {
+			break
+		}
+		usage -= textureBytes(g.visages[i])
+		g.evictTexture(i)
+	}
+}
+
+func (g *Game) evictTexture(index int) {
+	v := &g.visages[index]
+	g.invalidateMips(v)
+
+	e := &evictedTexture{
+		w:   v.image.Bounds().Dx(),
+		h:   v.image.Bounds().Dy(),
+		raw: make([]byte, imageBytes(v.image)),
+	}
+	v.image.ReadPixels(e.raw)
+	v.image.Deallocate()
+	v.evicted = e
+
+	go func() {
+		var buf bytes.Buffer
+		w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+		_, _ = w.Write(e.raw)
+		_ = w.Close()
+
+		g.m.Lock()
+		defer g.m.Unlock()
+		e.data = buf.Bytes()
+		e.raw = nil
+	}()
+}
+
+// Pixels of the evicted texture, decompressed when needed
+func (g *Game) evictedPixels(e *evictedTexture) ([]byte, error) {
+	g.m.Lock()
+	raw, data := e.raw, e.data
+	g.m.Unlock()
+	if raw != nil {
+		return raw, nil
+	}
+
+	pix := make([]byte, 4*e.w*e.h)
+	if _, err := io.ReadFull(flate.NewReader(bytes.NewReader(data)), pix); err != nil {
+		return nil, err
+	}
+	return pix, nil
+}
+
+func (g *Game) restoreTexture(index int) {
+	v := &g.visages[index]
+	if v.evicted == nil {
+		return
+	}
+
+	pix, err := g.evictedPixels(v.evicted)
+	v.evicted = nil
+	if err != nil {
+		g.notify(severityError, "Failed to restore an image: %v", err)
+		return
+	}
+	v.image.WritePixels(pix)
+	g.markDirty()
+}
+
+// Pixels for saving without uploading the texture again
+func (g *Game) readVisageImage(v Visage) *image.RGBA {
+	if v.evicted == nil {
+		return readImage(v.image)
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, v.evicted.w, v.evicted.h))
+	pix, err := g.evictedPixels(v.evicted)
+	if err != nil {
+		g.notify(severityError, "Failed to read an evicted image: %v", err)
+		return rgba
+	}
+	copy(rgba.Pix, pix)
+	return rgba
+}
+
+func (g *Game) drawMemoryDebug(screen *ebiten.Image) {
+	usage, evicted, compressed := g.textureUsage(), 0, 0
+	var top []int
+	for i, v := range g.visages {
+		if v.evicted != nil {
+			evicted++
+			compressed += len(v.evicted.data)
+		}
+		top = append(top, i)
+	}
+	sort.Slice(top, func(a, b int) bool {
+		return textureBytes(g.visages[top[a]]) > textureBytes(g.visages[top[b]])
+	})
+	if len(top) > memoryDebugTop {
+		top = top[:memoryDebugTop]
+	}
+
+	lines := []string{
+		fmt.Sprintf("Textures: %s / %s", formatSize(int64(usage)), formatSize(int64(g.textureBudget))),
+		fmt.Sprintf("Evicted: %d (%s compressed)", evicted, formatSize(int64(compressed))),
+	}
+	for _, i := range top {
+		v := g.visages[i]
+		if size := textureBytes(v); size > 0 {
+			lines = append(lines, fmt.Sprintf("  #%d %dx%d %s", v.id, v.w, v.h, formatSize(int64(size))))
+		}
+	}
+
+	y := 20
+	vector.DrawFilledRect(screen, 0, float32(y), 240, float32(len(lines)*16+4), color.RGBA{100, 100, 100, 200}, false)
+	for i, line := range lines {
+		ebitenutil.DebugPrintAt(screen, line, 0, y+i*16)
+	}
+}
+
+func (g *Game) memoryDebugAction(selectedIndex int) {
+	g.settings.MemoryDebug = !g.settings.MemoryDebug
+	g.saveSettings()
+}
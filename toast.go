@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Notification stacked in the bottom right corner until it expires
+type toast struct {
+	lines    []string
+	severity int
+	expires  time.Time
+}
+
+const (
+	severityInfo = iota
+	severityWarning
+	severityError
+)
+
+const (
+	toastLimit      = 5
+	toastMargin     = 12
+	toastPadding    = 8
+	toastLineHeight = 16
+	toastMaxChars   = 60
+	toastFade       = 500 * time.Millisecond
+)
+
+var toastDurations = []time.Duration{3 * time.Second, 5 * time.Second, 8 * time.Second}
+
+var toastColors = []color.RGBA{
+	{40, 40, 40, 220},
+	{170, 110, 0, 220},
+	{170, 30, 30, 220},
+}
+
+// Logs the message and shows it on the canvas, safe to call from any goroutine
+func (g *Game) notify(severity int, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	log.Print(msg)
+
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.toasts = append(g.toasts, toast{
+		lines:    wrapWords(msg, toastMaxChars),
+		severity: severity,
+		expires:  time.Now().Add(toastDurations[severity]),
+	})
+	if len(g.toasts) > toastLimit {
+		g.toasts = g.toasts[len(g.toasts)-toastLimit:]
+	}
+}
+
+func (g *Game) updateToasts() {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	now := time.Now()
+	toasts := g.toasts[:0]
+	for _, t := range g.toasts {
+		if now.Before(t.expires) {
+			toasts = append(toasts, t)
+		}
+	}
+	if len(g.toasts) > 0 { // They fade out, the expired ones are cleared too
+		g.markDirty()
+	}
+	g.toasts = toasts
+}
+
+// Newest toast at the bottom, older ones pushed up
+func (g *Game) drawToasts(screen *ebiten.Image) {
+	now := time.Now()
+	y := screen.Bounds().Dy() - toastMargin
+	for i := len(g.toasts) - 1; i >= 0; i-- {
+		t := g.toasts[i]
+		width := 0
+		for _, line := range t.lines {
+			if w := 6 * len(line); w > width { // The debug font is 6 pixels wide
+				width = w
+			}
+		}
+		width += 2 * toastPadding
+		height := len(t.lines)*toastLineHeight + 2*toastPadding
+		x := screen.Bounds().Dx() - toastMargin - width
+		y -= height
+
+		c := toastColors[t.severity]
+		if left := t.expires.Sub(now); left < toastFade {
+			c = fadeColor(c, float64(left)/float64(toastFade))
+		}
+		vector.DrawFilledRect(screen, float32(x), float32(y), float32(width), float32(height), c, false)
+		for j, line := range t.lines {
+			ebitenutil.DebugPrintAt(screen, line, x+toastPadding, y+toastPadding+j*toastLineHeight)
+		}
+		y -= toastMargin / 2
+	}
+}
+
+// Scales every channel, the colours are premultiplied so alpha alone would brighten them
+func fadeColor(c color.RGBA, f float64) color.RGBA {
+	return color.RGBA{
+		R: uint8(float64(c.R) * f),
+		G: uint8(float64(c.G) * f),
+		B: uint8(float64(c.B) * f),
+		A: uint8(float64(c.A) * f),
+	}
+}
+
+// Breaks the text into lines of at most n characters, longer words are split
+func wrapWords(s string, n int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		for len(word) > n {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, word[:n])
+			word = word[n:]
+		}
+		switch {
+		case line == "":
+			line = word
+		case len(line)+1+len(word) <= n:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"image"
+	"log"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Button running a registered action, the tooltip shows the keys bound to it
+type toolButton struct {
+	action  string
+	icon    *ebiten.Image
+	tooltip string
+	enabled func() bool // Nil follows the action
+}
+
+// Where the toolbar sits, next to the selected visage unless docked to a screen edge
+const (
+	dockNone = iota
+	dockLeft
+	dockTop
+	dockRight
+	dockBottom
+	dockCount
+)
+
+const (
+	toolbarGap      = 6 // Between the floating toolbar and the visage
+	toolbarMargin   = 8 // Between the docked toolbar and the screen edge
+	iconSize        = 28
+	iconPadding     = 2
+	tooltipPadding  = 4
+	tooltipGap      = 6
+	tooltipLineSize = 16
+)
+
+var dockNames = []string{"Floating", "Left", "Top", "Right", "Bottom"}
+
+func (g *Game) loadToolbar() {
+	buttons := []struct {
+		action, icon, tooltip string
+	}{
+		{"move", "assets/move.png", "Bring to front or send to back"},
+		{"flip", "assets/flip.png", "Flip"},
+		{"rotate", "assets/rotate.png", "Rotate"},
+		{"erase", "assets/erase.png", "Eraser"},
+		{"delete", "assets/delete.png", "Delete"},
+		{"copy", "assets/copy.png", "Duplicate"},
+	}
+
+	for _, b := range buttons {
+		img, _, err := ebitenutil.NewImageFromFile(b.icon)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g.toolbar = append(g.toolbar, toolButton{
+			action:  b.action,
+			icon:    img,
+			tooltip: b.tooltip,
+		})
+	}
+}
+
+func (g *Game) buttonEnabled(b toolButton) bool {
+	if b.enabled != nil {
+		return b.enabled()
+	}
+	a := g.actionByName(b.action)
+	return a != nil && a.isEnabled()
+}
+
+// Chrome rectangles of the buttons, empty while the floating toolbar has no visage to follow
+func (g *Game) toolbarRects() []image.Rectangle {
+	n := len(g.toolbar)
+	length := n * buttonSize
+	vertical := g.dock != dockTop && g.dock != dockBottom
+
+	var x, y int
+	switch g.dock {
+	case dockNone:
+		if len(g.visages) == 0 || !g.selected {
+			return nil
+		}
+		// Left of the visage, on its right once that goes off screen
+		v := g.chromeVisage(g.visages[g.selectedIndex])
+		x = v.x - buttonSize - toolbarGap
+		if x < 0 {
+			x = v.x + v.w + toolbarGap
+		}
+		x = clamp(x, 0, g.uiWidth-buttonSize)
+		y = clamp(v.y, 0, g.uiHeight-length)
+	case dockLeft:
+		x, y = toolbarMargin, (g.uiHeight-length)/2
+	case dockRight:
+		x, y = g.uiWidth-buttonSize-toolbarMargin, (g.uiHeight-length)/2
+	case dockTop:
+		x, y = (g.uiWidth-length)/2, toolbarMargin
+	case dockBottom:
+		x, y = (g.uiWidth-length)/2, g.uiHeight-buttonSize-toolbarMargin
+	}
+
+	rects := make([]image.Rectangle, n)
+	for i := range rects {
+		r := image.Rect(x, y, x+buttonSize, y+buttonSize)
+		if vertical {
+			rects[i] = r.Add(image.Pt(0, i*buttonSize))
+		} else {
+			rects[i] = r.Add(image.Pt(i*buttonSize, 0))
+		}
+	}
+	return rects
+}
+
+func clamp(v, lo, hi int) int {
+	if v > hi {
+		v = hi
+	}
+	if v < lo {
+		v = lo
+	}
+	return v
+}
+
+// Button under the cursor, -1 when there is none
+func (g *Game) buttonAt(x, y int) int {
+	for i, r := range g.toolbarRects() {
+		if image.Pt(x, y).In(r) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (g *Game) checkButtonClicks(x, y int) {
+	i := g.buttonAt(x, y)
+	if i < 0 {
+		return
+	}
+
+	g.clicking = true // Also keeps disabled buttons from starting a drag
+	if b := g.toolbar[i]; g.buttonEnabled(b) {
+		g.runAction(g.actionByName(b.action))
+	}
+}
+
+func (g *Game) drawToolbar(screen *ebiten.Image) {
+	rects := g.toolbarRects()
+	for i, r := range rects {
+		b := g.toolbar[i]
+		x, y := float32(r.Min.X), float32(r.Min.Y)
+		enabled := g.buttonEnabled(b)
+
+		c := g.theme().button
+		if !enabled {
+			c = fadeColor(c, 0.6)
+		}
+		vector.DrawFilledRect(screen, x, y, buttonSize, buttonSize, c, false)
+		if g.erasingToggle && enabled { // What still works while erasing
+			vector.DrawFilledRect(screen, x, y, buttonSize, buttonSize, g.theme().eraser, true)
+		}
+
+		op := &ebiten.DrawImageOptions{}
+		op.Filter = ebiten.FilterLinear
+		op.GeoM.Scale(float64(iconSize)/float64(b.icon.Bounds().Dx()), float64(iconSize)/float64(b.icon.Bounds().Dy()))
+		op.GeoM.Translate(float64(r.Min.X+iconPadding), float64(r.Min.Y+iconPadding))
+		if !enabled {
+			op.ColorScale.ScaleAlpha(0.4)
+		}
+		screen.DrawImage(b.icon, op)
+	}
+
+	if i := g.buttonAt(g.uiPoint(g.cursorX, g.cursorY)); i >= 0 {
+		g.drawTooltip(screen, g.toolbar[i], rects[i])
+	}
+}
+
+// Tooltip beside the button on the side facing the screen centre
+func (g *Game) drawTooltip(screen *ebiten.Image, b toolButton, r image.Rectangle) {
+	label := b.tooltip
+	if a := g.actionByName(b.action); a != nil && len(a.chords) > 0 {
+		label += " (" + a.keys() + ")"
+	}
+	w := 6*len(label) + 2*tooltipPadding // The debug font is 6 pixels wide
+	h := tooltipLineSize + tooltipPadding
+
+	x, y := r.Max.X+tooltipGap, r.Min.Y+(buttonSize-h)/2
+	switch {
+	case g.dock == dockTop:
+		x, y = r.Min.X, r.Max.Y+tooltipGap
+	case g.dock == dockBottom:
+		x, y = r.Min.X, r.Min.Y-h-tooltipGap
+	case r.Min.X > g.uiWidth/2:
+		x = r.Min.X - w - tooltipGap
+	}
+	x = clamp(x, 0, g.uiWidth-w)
+
+	vector.DrawFilledRect(screen, float32(x), float32(y), float32(w), float32(h), g.theme().panel, false)
+	ebitenutil.DebugPrintAt(screen, label, x+tooltipPadding, y+tooltipPadding/2)
+}
+
+// Cycles the toolbar between following the selection and the screen edges
+func (g *Game) dockAction(selectedIndex int) {
+	g.dock = (g.dock + 1) % dockCount
+	g.notify(severityInfo, "Toolbar: %s", dockNames[g.dock])
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Exact position and size of the selected visage in board pixels, a click on
+// a field starts typing into it and Enter applies the value, the rotation steps
+// by a quarter turn from the arrow clicked
+type transformInspector struct {
+	field int // Field being typed into, -1 while none
+	input string
+}
+
+const (
+	fieldX = iota
+	fieldY
+	fieldWidth
+	fieldHeight
+	fieldRotation
+	fieldScale
+	fieldCount
+)
+
+const (
+	inspectorWidth  = 240
+	inspectorMargin = 12
+	nudgeFar        = 10 // Board pixels moved with Shift held
+)
+
+var (
+	fieldLabels = []string{"X", "Y", "Width", "Height", "Rotation", "Scale"}
+	fieldUnits  = []string{"px", "px", "px", "px", "deg", "%"}
+)
+
+// Moves the selection by a board pixel, by ten with Shift held, whatever the zoom
+func (g *Game) nudge(dx, dy int) {
+	if len(g.visages) == 0 || !g.selected || g.erasingToggle || g.selectTool != selectNone {
+		return
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyShift) {
+		dx, dy = dx*nudgeFar, dy*nudgeFar
+	}
+
+	v := &g.visages[g.selectedIndex]
+	if v.locked { // The group stays together
+		return
+	}
+	v.x += dx
+	v.y += dy
+	if g.group != nil {
+		g.dragGroup(dx, dy)
+	}
+	g.attachShapeEnds(g.selectedIndex)
+}
+
+func (g *Game) nudgeLeftAction(selectedIndex int) {
+	g.nudge(-1, 0)
+}
+
+func (g *Game) nudgeRightAction(selectedIndex int) {
+	g.nudge(1, 0)
+}
+
+func (g *Game) nudgeUpAction(selectedIndex int) {
+	g.nudge(0, -1)
+}
+
+func (g *Game) nudgeDownAction(selectedIndex int) {
+	g.nudge(0, 1)
+}
+
+// Value of the field for the selected visage, false when it does not apply
+func (g *Game) transformValue(field int) (float64, bool) {
+	if len(g.visages) == 0 || !g.selected {
+		return 0, false
+	}
+	v := g.visages[g.selectedIndex]
+	switch field {
+	case fieldX:
+		return float64(v.x), true
+	case fieldY:
+		return float64(v.y), true
+	case fieldWidth:
+		return float64(v.w), v.loading == nil
+	case fieldHeight:
+		return float64(v.h), v.loading == nil
+	case fieldRotation:
+		return float64(v.rotation), v.image != nil
+	case fieldScale:
+		if v.image == nil {
+			return 0, false
+		}
+		w, _ := naturalSize(v)
+		return float64(v.w) / float64(w) * 100, true
+	}
+	return 0, false
+}
+
+// Absolute values, or relative to the current one when starting with + - * or /,
+// "=" sets a negative value. A trailing unit must match the field, except that
+// a percentage of any field is taken of its current value.
+func evalTransformInput(input string, current float64, unit string) (float64, error) {
+	s := strings.ToLower(strings.ReplaceAll(input, " ", ""))
+	op := byte('=')
+	if s != "" && strings.IndexByte("+-*/=", s[0]) >= 0 {
+		op, s = s[0], s[1:]
+	}
+
+	percent := false
+	for _, u := range []string{"px", "deg", "°", "%"} {
+		if !strings.HasSuffix(s, u) {
+			continue
+		}
+		s = strings.TrimSuffix(s, u)
+		switch {
+		case u == unit, u == "°" && unit == "deg":
+		case u == "%":
+			percent = true
+		default:
+			return 0, fmt.Errorf("the unit is %s, not %s", unit, u)
+		}
+		break
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, fmt.Errorf("%q is not a number", input)
+	}
+	if percent {
+		if op == '*' || op == '/' {
+			n /= 100
+		} else {
+			n = current * n / 100
+		}
+	}
+
+	switch op {
+	case '+':
+		return current + n, nil
+	case '-':
+		return current - n, nil
+	case '*':
+		return current * n, nil
+	case '/':
+		if n == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return current / n, nil
+	}
+	return n, nil
+}
+
+func (g *Game) setTransform(field int, value float64) error {
+	v := &g.visages[g.selectedIndex]
+	if v.locked {
+		return errors.New("the visage is locked")
+	}
+
+	switch field {
+	case fieldX:
+		v.x = int(math.Round(value))
+	case fieldY:
+		v.y = int(math.Round(value))
+	case fieldWidth, fieldHeight:
+		if value < 1 {
+			return errors.New("the size must be at least 1 px")
+		}
+		size := int(math.Round(value))
+		if field == fieldWidth {
+			v.w = size
+		} else {
+			v.h = size
+		}
+	case fieldScale:
+		if value <= 0 {
+			return errors.New("the scale must be above 0%")
+		}
+		w, h := naturalSize(*v)
+		v.w = int(math.Max(1, math.Round(float64(w)*value/100)))
+		v.h = int(math.Max(1, math.Round(float64(h)*value/100)))
+	}
+
+	if v.image != nil && field != fieldX && field != fieldY {
+		g.refreshVectorImage(g.selectedIndex)
+		g.refreshProxy(g.selectedIndex)
+	}
+	g.attachShapeEnds(g.selectedIndex)
+	return nil
+}
+
+// Turns the selected visage a quarter clockwise, or anticlockwise when back is set
+func (g *Game) stepRotation(back bool) {
+	if g.visages[g.selectedIndex].locked {
+		g.notify(severityWarning, "%s: the visage is locked", fieldLabels[fieldRotation])
+		return
+	}
+	turns := 1
+	if back {
+		turns = 3
+	}
+	for ; turns > 0; turns-- {
+		g.rotateAction(g.selectedIndex)
+	}
+	g.refreshVectorImage(g.selectedIndex)
+	g.refreshProxy(g.selectedIndex)
+	g.attachShapeEnds(g.selectedIndex)
+}
+
+func (g *Game) inspectorEditing() bool {
+	return g.inspector != nil && g.inspector.field >= 0
+}
+
+// Applies what was typed, the field stays open to correct it when the value is refused
+func (g *Game) applyInspectorInput() bool {
+	t := g.inspector
+	current, ok := g.transformValue(t.field)
+	if !ok {
+		return true
+	}
+	value, err := evalTransformInput(t.input, current, fieldUnits[t.field])
+	if err == nil {
+		err = g.setTransform(t.field, value)
+	}
+	if err != nil {
+		g.notify(severityWarning, "%s: %v", fieldLabels[t.field], err)
+		return false
+	}
+	return true
+}
+
+// Takes the keyboard while a field is being typed into, Tab applies it and moves on
+func (g *Game) handleInspectorKeys() {
+	if !g.inspectorEditing() {
+		return
+	}
+	t := g.inspector
+	if _, ok := g.transformValue(t.field); !ok { // The selection went away
+		t.field, t.input = -1, ""
+		return
+	}
+	g.markDirty() // The caret blinks
+
+	g.inputChars = ebiten.AppendInputChars(g.inputChars[:0])
+	t.input += string(g.inputChars)
+	if repeatingKeyPressed(ebiten.KeyBackspace) && len(t.input) > 0 {
+		r := []rune(t.input)
+		t.input = string(r[:len(r)-1])
+	}
+
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyEscape):
+		t.field, t.input = -1, ""
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyNumpadEnter):
+		if t.input == "" || g.applyInspectorInput() {
+			t.field, t.input = -1, ""
+		}
+	case inpututil.IsKeyJustPressed(ebiten.KeyTab):
+		if t.input != "" && !g.applyInspectorInput() {
+			return
+		}
+		next := t.field
+		for {
+			next = (next + 1) % fieldCount
+			if _, ok := g.transformValue(next); ok && next != fieldRotation { // Stepped, not typed
+				break
+			}
+		}
+		t.field, t.input = next, ""
+	}
+}
+
+func (g *Game) inspectorBounds() image.Rectangle {
+	x := g.uiWidth - inspectorWidth - inspectorMargin
+	h := (fieldCount+1)*paletteRowHeight + 3*palettePadding
+	return image.Rect(x, inspectorMargin, x+inspectorWidth, inspectorMargin+h)
+}
+
+// Starts typing into the field under the cursor, true while the inspector has the mouse
+func (g *Game) handleInspector(x, y int) bool {
+	t := g.inspector
+	if t == nil || !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		return false
+	}
+	bounds := g.inspectorBounds()
+	if !image.Pt(x, y).In(bounds) {
+		t.field, t.input = -1, "" // Clicking away drops what was typed
+		return false
+	}
+
+	g.clicking = true // Keeps the press from starting a drag
+	top := bounds.Min.Y + 2*palettePadding + paletteRowHeight
+	if field := (y - top) / paletteRowHeight; y >= top && field < fieldCount {
+		if value, ok := g.transformValue(field); ok && field == fieldRotation {
+			t.field, t.input = -1, ""
+			w, _ := text.Measure(rotationLabel(value), g.noteFace(paletteFontSize), 0)
+			g.stepRotation(x < bounds.Max.X-2*palettePadding-int(w)/2) // Left of the middle of the label
+		} else if ok {
+			if g.editNote != nil { // Only one of them takes the typing
+				g.stopEditing()
+			}
+			t.field, t.input = field, ""
+		}
+	}
+	return true
+}
+
+func formatTransform(value float64, unit string) string {
+	s := strconv.FormatFloat(math.Round(value*10)/10, 'f', -1, 64)
+	if unit == "deg" {
+		return s + "°"
+	}
+	return s + " " + unit
+}
+
+// Arrows either side of the angle, each turns it a quarter that way
+func rotationLabel(value float64) string {
+	return "< " + formatTransform(value, fieldUnits[fieldRotation]) + " >"
+}
+
+func (g *Game) drawInspector(screen *ebiten.Image) {
+	t := g.inspector
+	bounds := g.inspectorBounds()
+	x, y := float32(bounds.Min.X), float32(bounds.Min.Y)
+	vector.DrawFilledRect(screen, x, y, float32(bounds.Dx()), float32(bounds.Dy()), g.theme().panel, false)
+
+	face := g.noteFace(paletteFontSize)
+	title := "Transform"
+	if len(g.visages) > 0 && g.selected && g.visages[g.selectedIndex].locked {
+		title += " (locked)"
+	}
+	g.drawPaletteText(screen, face, title, bounds.Min.X+2*palettePadding, bounds.Min.Y+palettePadding, colorPaletteText, text.AlignStart)
+	g.drawPaletteText(screen, face, "+20, *0.5, =-10", bounds.Max.X-2*palettePadding, bounds.Min.Y+palettePadding, colorPaletteDisabled, text.AlignEnd)
+
+	top := bounds.Min.Y + 2*palettePadding + paletteRowHeight
+	for field := 0; field < fieldCount; field++ {
+		ry := top + field*paletteRowHeight
+		value, ok := g.transformValue(field)
+		label, clr := "-", colorPaletteDisabled
+		if ok {
+			label, clr = formatTransform(value, fieldUnits[field]), colorPaletteText
+		}
+		if ok && field == fieldRotation {
+			label = rotationLabel(value)
+		}
+		if field == t.field {
+			vector.DrawFilledRect(screen, x+palettePadding, float32(ry), inspectorWidth-2*palettePadding, paletteRowHeight, colorPaletteCursor, false)
+			label = t.input
+			if g.ticks/30%2 == 0 { // Blinking caret
+				label += "|"
+			}
+		}
+		g.drawPaletteText(screen, face, fieldLabels[field], bounds.Min.X+2*palettePadding, ry, clr, text.AlignStart)
+		g.drawPaletteText(screen, face, label, bounds.Max.X-2*palettePadding, ry, clr, text.AlignEnd)
+	}
+}
+
+func (g *Game) inspectorAction(selectedIndex int) {
+	if g.inspector != nil {
+		g.inspector = nil
+		return
+	}
+	g.inspector = &transformInspector{field: -1}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Snapshot of the pixels of a visage taken before an edit
+type undoEntry struct {
+	id     int
+	frame  int // Frame of an animation the pixels were taken from
+	w, h   int
+	pixels []byte
+}
+
+const undoLimit = 20
+
+func (g *Game) pushUndo(index int) {
+	g.prepareEdit(index)
+	v := g.visages[index]
+	if v.image == nil {
+		return
+	}
+
+	entry := undoEntry{
+		id:     v.id,
+		w:      v.image.Bounds().Dx(),
+		h:      v.image.Bounds().Dy(),
+		pixels: make([]byte, 4*v.image.Bounds().Dx()*v.image.Bounds().Dy()),
+	}
+	if v.anim != nil {
+		entry.frame = v.anim.frame
+	}
+	v.image.ReadPixels(entry.pixels)
+	g.visages[index].svg = nil // Rasterising again would lose the edit
+	g.invalidateMips(&g.visages[index])
+
+	g.undoStack = append(g.undoStack, entry)
+	if len(g.undoStack) > undoLimit {
+		g.undoStack = g.undoStack[len(g.undoStack)-undoLimit:]
+	}
+}
+
+func (g *Game) undoAction(selectedIndex int) {
+	for len(g.undoStack) > 0 {
+		entry := g.undoStack[len(g.undoStack)-1]
+		g.undoStack = g.undoStack[:len(g.undoStack)-1]
+
+		i := g.visageIndexByID(entry.id)
+		if i < 0 { // Visage was deleted since
+			continue
+		}
+
+		g.prepareEdit(i)
+		v := &g.visages[i]
+		v.evicted = nil                                        // Every pixel is written below
+		if v.anim != nil && entry.frame < len(v.anim.frames) { // The frame shown may have changed since
+			frame := v.anim.frames[entry.frame]
+			if frame.Bounds().Dx() != entry.w || frame.Bounds().Dy() != entry.h {
+				frame.Deallocate()
+				frame = ebiten.NewImage(entry.w, entry.h)
+				v.anim.frames[entry.frame] = frame
+			}
+			frame.WritePixels(entry.pixels)
+			v.image = v.anim.frames[v.anim.frame]
+		} else {
+			if v.image.Bounds().Dx() != entry.w || v.image.Bounds().Dy() != entry.h {
+				v.replaceImage(ebiten.NewImage(entry.w, entry.h))
+			}
+			v.image.WritePixels(entry.pixels)
+		}
+		g.invalidateMips(v)
+		g.clearSelection()
+		return
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// Visages are kept in board coordinates, the view is applied when they are
+// drawn and to the cursor: screen = board * zoom + offset
+type view struct {
+	zoom float64
+	x, y float64
+}
+
+// Transition between two views, only the view moves so nothing is rounded along the way
+type viewAnimation struct {
+	from, to view
+	tick     int
+}
+
+const (
+	fitViewMargin = 40
+	viewAnimTicks = 20
+	viewpoints    = 9
+	zoomMin       = 0.01
+	zoomMax       = 100
+)
+
+func (v view) screenPoint(x, y float64) (float64, float64) {
+	return x*v.zoom + v.x, y*v.zoom + v.y
+}
+
+func (v view) boardPoint(x, y float64) (float64, float64) {
+	return (x - v.x) / v.zoom, (y - v.y) / v.zoom
+}
+
+// Board rectangle covered by the screen rectangle
+func (v view) boardRect(r image.Rectangle) image.Rectangle {
+	x0, y0 := v.boardPoint(float64(r.Min.X), float64(r.Min.Y))
+	x1, y1 := v.boardPoint(float64(r.Max.X), float64(r.Max.Y))
+	return image.Rect(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Ceil(x1)), int(math.Ceil(y1)))
+}
+
+// Board pixel under the screen point
+func (g *Game) toBoard(x, y int) (int, int) {
+	bx, by := g.view.boardPoint(float64(x), float64(y))
+	return int(math.Floor(bx)), int(math.Floor(by))
+}
+
+// Copy of the visage placed on screen, the edges are rounded so neighbours
+// still meet. Notes and captions keep their pointer for the caret and scale
+// their text when drawn.
+func (g *Game) screenVisage(v Visage) Visage {
+	x0, y0 := g.view.screenPoint(float64(v.x), float64(v.y))
+	x1, y1 := g.view.screenPoint(float64(v.x+v.w), float64(v.y+v.h))
+	v.x, v.y = int(math.Round(x0)), int(math.Round(y0))
+	v.w, v.h = int(math.Round(x1))-v.x, int(math.Round(y1))-v.y
+	if v.shape != nil && g.view.zoom != 1 {
+		v.shape = v.shape.clone()
+		v.shape.strokeWidth *= float32(g.view.zoom)
+	}
+	return v
+}
+
+// Copy of the visage placed on the chrome layer, for the borders, handles and
+// sliders that keep their size at any zoom
+func (g *Game) chromeVisage(v Visage) Visage {
+	scale := g.uiScale()
+	x0, y0 := g.view.screenPoint(float64(v.x), float64(v.y))
+	x1, y1 := g.view.screenPoint(float64(v.x+v.w), float64(v.y+v.h))
+	v.x, v.y = int(math.Round(x0/scale)), int(math.Round(y0/scale))
+	v.w, v.h = int(math.Round(x1/scale))-v.x, int(math.Round(y1/scale))-v.y
+	return v
+}
+
+// Point on the chrome layer under the screen point
+func (g *Game) uiPoint(x, y int) (int, int) {
+	scale := g.uiScale()
+	return int(math.Floor(float64(x) / scale)), int(math.Floor(float64(y) / scale))
+}
+
+// View after scaling the screen by the factor and moving it
+func (v view) transform(scale, dx, dy float64) view {
+	return view{v.zoom * scale, v.x*scale + dx, v.y*scale + dy}
+}
+
+// Zoom interpolated in log space with the board point at the screen centre moving linearly
+func (g *Game) lerpView(from, to view, t float64) view {
+	cx, cy := float64(g.screenWidth)/2, float64(g.screenHeight)/2
+	fx, fy := from.boardPoint(cx, cy)
+	tx, ty := to.boardPoint(cx, cy)
+	zoom := math.Exp(math.Log(from.zoom) + (math.Log(to.zoom)-math.Log(from.zoom))*t)
+	bx, by := fx+(tx-fx)*t, fy+(ty-fy)*t
+	return view{zoom, cx - bx*zoom, cy - by*zoom}
+}
+
+func (g *Game) animateView(to view) {
+	g.finishViewAnimation()
+	to.zoom = math.Max(zoomMin, math.Min(zoomMax, to.zoom))
+	g.viewAnim = &viewAnimation{from: g.view, to: to}
+}
+
+func (g *Game) updateView() {
+	a := g.viewAnim
+	if a == nil {
+		return
+	}
+
+	a.tick++
+	t := float64(a.tick) / viewAnimTicks
+	if a.tick >= viewAnimTicks {
+		t = 1
+	}
+	t = t * t * (3 - 2*t) // Eases in and out
+	g.setView(g.lerpView(a.from, a.to, t))
+
+	if a.tick >= viewAnimTicks {
+		g.viewAnim = nil
+		g.setView(a.to)
+		for i, v := range g.visages {
+			if v.image != nil { // Sharper rasters for what was scaled up
+				g.refreshVectorImage(i)
+				g.refreshProxy(i)
+			}
+		}
+	}
+}
+
+// Jumps to the end of a running transition, before the user moves anything
+func (g *Game) finishViewAnimation() {
+	if g.viewAnim != nil {
+		g.viewAnim.tick = viewAnimTicks
+		g.updateView()
+	}
+}
+
+// Takes g.m as the import goroutines place their placeholders through the view
+func (g *Game) setView(to view) {
+	g.m.Lock()
+	g.view = to
+	g.m.Unlock()
+	g.markDirty()
+}
+
+func (g *Game) panBy(dx, dy float64) {
+	g.setView(view{g.view.zoom, g.view.x + dx, g.view.y + dy})
+}
+
+// View framing the board rectangle with a margin
+func (g *Game) frameRect(r image.Rectangle) view {
+	w := float64(g.screenWidth - 2*fitViewMargin)
+	h := float64(g.screenHeight - 2*fitViewMargin)
+	zoom := math.Min(w/math.Max(1, float64(r.Dx())), h/math.Max(1, float64(r.Dy())))
+	cx, cy := float64(r.Min.X+r.Max.X)/2, float64(r.Min.Y+r.Max.Y)/2
+	return view{zoom, float64(g.screenWidth)/2 - cx*zoom, float64(g.screenHeight)/2 - cy*zoom}
+}
+
+// Frames every visible visage
+func (g *Game) fitViewAction(selectedIndex int) {
+	bounds := g.visibleBounds()
+	if bounds.Empty() {
+		return
+	}
+	g.animateView(g.frameRect(bounds))
+}
+
+// Frames the selected visage, or every visage selected together
+func (g *Game) frameSelectionAction(selectedIndex int) {
+	if len(g.visages) == 0 || !g.selected {
+		return
+	}
+
+	bounds := g.visageBounds(g.visages[selectedIndex])
+	for _, v := range g.visages {
+		if g.group[v.id] && !v.hidden {
+			bounds = bounds.Union(g.visageBounds(v))
+		}
+	}
+	g.animateView(g.frameRect(bounds))
+}
+
+// Back to the board at its actual size around the screen centre
+func (g *Game) actualSizeAction(selectedIndex int) {
+	scale := 1 / g.view.zoom
+	cx, cy := float64(g.screenWidth)/2, float64(g.screenHeight)/2
+	g.animateView(g.view.transform(scale, cx*(1-scale), cy*(1-scale)))
+}
+
+func (g *Game) saveViewpoint(n int) {
+	g.finishViewAnimation()
+	if g.viewpoints == nil {
+		g.viewpoints = map[int]view{}
+	}
+	g.viewpoints[n] = g.view
+	g.notify(severityInfo, "Saved viewpoint %d", n)
+}
+
+func (g *Game) goToViewpoint(n int) {
+	to, ok := g.viewpoints[n]
+	if !ok {
+		g.notify(severityInfo, "Viewpoint %d is not set, save it with Ctrl+%d", n, n)
+		return
+	}
+	g.animateView(to)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+const (
+	toleranceMin     = 0
+	toleranceMax     = 100
+	toleranceDefault = 20
+	toleranceStep    = 2
+	featherMin       = 0
+	featherMax       = 50
+	featherDefault   = 5
+)
+
+// Colour of a premultiplied pixel with alpha, all channels in 0-1
+func pixelColor(pix []byte, i int) [4]float64 {
+	a := float64(pix[i+3]) / 255
+	if a == 0 {
+		return [4]float64{}
+	}
+	return [4]float64{
+		float64(pix[i]) / 255 / a,
+		float64(pix[i+1]) / 255 / a,
+		float64(pix[i+2]) / 255 / a,
+		a,
+	}
+}
+
+// Distance between two colours in 0-1, transparency counts as a fourth channel
+func colorDistance(c1, c2 [4]float64) float64 {
+	var d float64
+	for i := range c1 {
+		d += (c1[i] - c2[i]) * (c1[i] - c2[i])
+	}
+	return math.Sqrt(d) / 2
+}
+
+// Multiplies the alpha of a premultiplied pixel, truncating so repeated partial erasing reaches zero
+func scaleAlpha(pix []byte, i int, factor float64) {
+	for c := 0; c < 4; c++ {
+		pix[i+c] = uint8(float64(pix[i+c]) * factor)
+	}
+}
+
+func (g *Game) handleWand(v *Visage, x, y int) {
+	if x < v.x || x >= v.x+v.w || y < v.y || y >= v.y+v.h {
+		return
+	}
+
+	w, h := v.image.Bounds().Dx(), v.image.Bounds().Dy()
+	px, py := getPixelCoordinates(v, x, y)
+	if px < 0 || px >= w || py < 0 || py >= h {
+		return
+	}
+
+	pix := make([]byte, 4*w*h)
+	v.image.ReadPixels(pix)
+	g.wandColor = pixelColor(pix, 4*(py*w+px))
+	g.wandHasColor = true
+
+	global := ebiten.IsKeyPressed(ebiten.KeyShift)
+	g.setSelection(v.image, selectSimilar(pix, w, h, px, py, float64(g.wandTolerance)/100, global))
+}
+
+// Flood fills from the seed, or takes every matching pixel when global
+func selectSimilar(pix []byte, w, h, px, py int, tolerance float64, global bool) []float32 {
+	mask := make([]float32, w*h)
+	seed := pixelColor(pix, 4*(py*w+px))
+	matches := func(i int) bool {
+		return colorDistance(pixelColor(pix, 4*i), seed) <= tolerance
+	}
+
+	if global {
+		for i := range mask {
+			if matches(i) {
+				mask[i] = 1
+			}
+		}
+		return mask
+	}
+
+	stack := []int{py*w + px}
+	mask[py*w+px] = 1
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		x, y := i%w, i/w
+
+		neighbours := [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}}
+		for _, n := range neighbours {
+			if n[0] < 0 || n[0] >= w || n[1] < 0 || n[1] >= h {
+				continue
+			}
+			j := n[1]*w + n[0]
+			if mask[j] == 0 && matches(j) {
+				mask[j] = 1
+				stack = append(stack, j)
+			}
+		}
+	}
+	return mask
+}
+
+// Keys out the colour picked with the wand, or the top left pixel
+func (g *Game) chromaKeyAction(selectedIndex int) {
+	if len(g.visages) == 0 || !g.selected || g.visages[selectedIndex].image == nil || g.erasingToggle {
+		return
+	}
+
+	g.pushUndo(selectedIndex)
+
+	v := &g.visages[selectedIndex]
+	w, h := v.image.Bounds().Dx(), v.image.Bounds().Dy()
+	pix := make([]byte, 4*w*h)
+	v.image.ReadPixels(pix)
+
+	key := pixelColor(pix, 0)
+	if g.selectTool == selectWand && g.wandHasColor {
+		key = g.wandColor
+	}
+	chromaKey(pix, key, float64(g.wandTolerance)/100, float64(g.feather)/100)
+	v.image.WritePixels(pix)
+	g.clearSelection()
+}
+
+// Pixels within tolerance become transparent, the feather band fades them in
+func chromaKey(pix []byte, key [4]float64, tolerance, feather float64) {
+	for i := 0; i < len(pix); i += 4 {
+		d := colorDistance(pixelColor(pix, i), key)
+		switch {
+		case d <= tolerance:
+			scaleAlpha(pix, i, 0)
+		case d < tolerance+feather:
+			scaleAlpha(pix, i, (d-tolerance)/feather)
+		}
+	}
+}
+
+func (g *Game) updateTolerance(value int) {
+	g.wandTolerance = value
+	if g.wandTolerance < toleranceMin {
+		g.wandTolerance = toleranceMin
+	} else if g.wandTolerance > toleranceMax {
+		g.wandTolerance = toleranceMax
+	}
+}
+
+func (g *Game) updateFeather(value int) {
+	g.feather = value
+	if g.feather < featherMin {
+		g.feather = featherMin
+	} else if g.feather > featherMax {
+		g.feather = featherMax
+	}
+}
+
+func (g *Game) featherLargerAction(selectedIndex int) {
+	g.updateFeather(g.feather + 1)
+}
+
+func (g *Game) featherSmallerAction(selectedIndex int) {
+	g.updateFeather(g.feather - 1)
+}
@@ -0,0 +1,17 @@
+//go:build !js
+
+package main
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Floating, click-through and translucent windows only exist on the desktop
+const desktop = true
+
+// The screen is always transparent so the background can be turned off while running
+func runGame(g *Game) error {
+	return ebiten.RunGameWithOptions(g, &ebiten.RunGameOptions{
+		ScreenTransparent: true,
+	})
+}
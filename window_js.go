@@ -0,0 +1,13 @@
+//go:build js
+
+package main
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+const desktop = false
+
+func runGame(g *Game) error {
+	return ebiten.RunGame(g)
+}